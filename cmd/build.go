@@ -5,22 +5,50 @@ package cmd
 
 import (
 	"fmt"
+	"log"
+	"os"
 
+	"github.com/spelens-gud/golangci-scope/internal/build"
+	"github.com/spelens-gud/golangci-scope/internal/cover"
 	"github.com/spf13/cobra"
 )
 
 // buildCmd represents the build command
 var buildCmd = &cobra.Command{
-	Use:   "build",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Use:   "build [packages]",
+	Short: "Do cover for all go files and execute go build command",
+	Long: `Build command will copy the project code and its necessary dependencies to a temporary directory,
+then do cover for the target, binaries will be generated to their original place.
+When several main packages are selected, --output is a directory holding all the binaries.
+
+The generated binary registers itself to the coverage center given by --center when it starts.`,
+	Example: `
+# Build the current binary with cover variables injected. The binary will be generated in the current folder.
+golangci-scope build
+
+# Build the current binary with cover variables injected, and set the registry center to http://127.0.0.1:7777.
+golangci-scope build --center=http://127.0.0.1:7777
+
+# Build the current binary with cover variables injected, and redirect output to /to/this/path.
+golangci-scope build --output /to/this/path
+
+# Build all the binaries under ./cmd with cover variables injected, and write them to the ./bin directory.
+golangci-scope build ./cmd/... --output ./bin
+
+# Build several specified binaries with cover variables injected, they share the counters of the common dependencies.
+golangci-scope build ./cmd/api ./cmd/worker --output ./bin
+
+# Build the current binary with cover variables injected, and set necessary build flags: -ldflags "-extldflags -static" -tags="embed kodo".
+golangci-scope build --buildflags="-ldflags '-extldflags -static' -tags='embed kodo'"
+`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("build called")
+		wd, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("Fail to build: %v", err)
+		}
+		includePkgs, excludePkgs = packageRules(cmd.Flags())
+		checkBackend()
+		runBuild(args, wd)
 	},
 }
 
@@ -28,16 +56,60 @@ var buildOutput string
 
 func init() {
 	addBuildFlags(buildCmd.Flags())
-	buildCmd.Flags().StringVarP(&buildOutput, "output", "o", "", "it forces build to write the resulting executable to the named output file")
+	buildCmd.Flags().StringVarP(&buildOutput, "output", "o", "", "it forces build to write the resulting executable to the named output file, or the named directory when building several main packages")
 	rootCmd.AddCommand(buildCmd)
+}
 
-	// Here you will define your flags and configuration settings.
+func runBuild(args []string, wd string) {
+	gocBuild, err := build.NewBuild(buildFlags, args, wd, buildOutput, build.WithOverlay(overlay))
+	if err != nil {
+		log.Fatalf("Fail to build: %v", err)
+	}
+	// remove temporary directory if needed
+	defer gocBuild.Clean()
+	if coverReplaced {
+		if err := gocBuild.CpReplacedModules(); err != nil {
+			log.Fatalf("Fail to build: %v", err)
+		}
+	}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// buildCmd.PersistentFlags().String("foo", "", "A help for foo")
+	// execute covers for the target source with original buildFlags and new GOPATH( tmp:original )
+	ci := &cover.CoverInfo{
+		Args:                     buildFlags,
+		GoPath:                   gocBuild.NewGOPATH,
+		Target:                   gocBuild.SourceDir(),
+		Mode:                     coverMode.String(),
+		AgentPort:                agentPort.String(),
+		Center:                   center,
+		Singleton:                singleton,
+		PushInterval:             pushInterval,
+		IsMod:                    gocBuild.IsMod,
+		ModRootPath:              gocBuild.ModRootPath,
+		OneMainPackage:           true, // it is a go build
+		MainPackages:             gocBuild.MainPackages,
+		GlobalCoverVarImportPath: gocBuild.GlobalCoverVarImportPath,
+		GoWork:                   gocBuild.NewGOWORK,
+		ExtraPackages:            gocBuild.ExtraPackages,
+		OverlayFile:              gocBuild.OverlayFile,
+		CacheDir:                 coverCacheDir(),
+		IncludePackages:          includePkgs,
+		ExcludePackages:          excludePkgs,
+		Backend:                  backend.String(),
+	}
+	ci.Revision, ci.Modified = gocBuild.VCSRevision()
+	err = cover.Execute(ci)
+	if err != nil {
+		log.Fatalf("Fail to build: %v", err)
+	}
+	if backend.Native() {
+		gocBuild.CoverFlags = nativeCoverFlags(ci.CoverPackages)
+	}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// buildCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	if err := gocBuild.Build(); err != nil {
+		log.Fatalf("Fail to build: %v", err)
+	}
+	if err := gocBuild.WriteManifests(ci.Manifests); err != nil {
+		log.Fatalf("Fail to write the manifest: %v", err)
+	}
+	fmt.Printf("[goc] instrumented binary generated: %s \n", gocBuild.Target)
 }
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+)
+
+// clearCmd represents the clear command.
+var clearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear code coverage counters of the services under test",
+	Long: `Clear code coverage counters for the services under test at runtime,
+so the next round of testing starts from zero.`,
+	Example: `
+# Clear coverage counter of all services from default register center http://127.0.0.1:7777.
+golangci-scope clear
+
+# Clear coverage counter of several specified services. Use 'service' and 'address' flag at the same time may cause ambiguity, please use them separately.
+golangci-scope clear --service=service1,service2
+
+# Clear coverage counter of several specified addresses from specified register center.
+golangci-scope clear --center=http://192.168.1.1:8080 --address=address1,address2
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := cover.ProfileParam{
+			Service: svrList,
+			Address: addrList,
+		}
+		res, err := cover.NewWorker(center).Clear(p)
+		if err != nil {
+			log.Fatalf("call host %v failed, err: %v, response: %v", center, err, string(res))
+		}
+		if err := printServiceResults(res); err != nil {
+			log.Fatalf("failed to print result, err: %v", err)
+		}
+	},
+}
+
+func init() {
+	clearCmd.Flags().StringSliceVarP(&svrList, "service", "", nil, "service name to clear profile, see 'golangci-scope list' for all services.")
+	clearCmd.Flags().StringSliceVarP(&addrList, "address", "", nil, "address to clear profile, see 'golangci-scope list' for all addresses.")
+	addBasicFlags(clearCmd.Flags())
+	addOutputFlags(clearCmd.Flags())
+	rootCmd.AddCommand(clearCmd)
+}
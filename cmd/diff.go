@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+	cov "golang.org/x/tools/cover"
+)
+
+// diffCmd represents the diff command.
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Report the coverage of the lines changed since a git revision",
+	Long: `Diff command intersects the lines changed in the working tree since it forked from the base revision
+with the blocks of the profile, and reports the covered and uncovered changed lines of each file.
+The changed lines which are not in any block, such as comments and declarations, are not counted.
+It exits with a non-zero code if the coverage of the changed lines is below --threshold.`,
+	Example: `
+# Report the coverage of the lines changed since the branch forked from origin/main.
+golangci-scope diff --base origin/main --profile cover.out
+
+# Fail the CI job if less than 80 percent of the changed lines are covered.
+golangci-scope diff --base origin/main --profile cover.out --threshold 80
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profiles, err := cov.ParseProfiles(diffProfile)
+		if err != nil {
+			log.Fatalf("failed to parse profile %s, err: %v", diffProfile, err)
+		}
+		checkout, err := cover.FindCheckout(".")
+		if err != nil {
+			log.Fatalf("failed to find the module, err: %v", err)
+		}
+		root, changed, err := cover.ChangedLines(".", diffBase)
+		if err != nil {
+			log.Fatalf("failed to get the changed lines, err: %v", err)
+		}
+		d, err := cover.IntersectDiff(profiles, checkout, root, changed)
+		if err != nil {
+			log.Fatalf("failed to intersect the changed lines with the profile, err: %v", err)
+		}
+		if err := printDiffCoverage(d); err != nil {
+			log.Fatalf("failed to print the diff coverage, err: %v", err)
+		}
+		if d.Percent() < diffThreshold {
+			fmt.Fprintf(os.Stderr, "[goc] diff coverage %.1f%% is below the threshold %.1f%%\n", d.Percent(), diffThreshold)
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	diffBase      string  // --base flag
+	diffProfile   string  // --profile flag
+	diffThreshold float64 // --threshold flag
+)
+
+func init() {
+	diffCmd.Flags().StringVar(&diffBase, "base", "origin/main", "the git revision the changes are compared with, from their merge base")
+	diffCmd.Flags().StringVar(&diffProfile, "profile", "cover.out", "the coverage profile")
+	diffCmd.Flags().Float64Var(&diffThreshold, "threshold", 0, "the minimum percentage of the covered changed lines, exit with code 1 if below")
+	addOutputFlags(diffCmd.Flags())
+	rootCmd.AddCommand(diffCmd)
+}
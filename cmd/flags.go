@@ -2,7 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"net"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -21,6 +25,14 @@ var (
 	buildFlags string    // 构建参数
 	singleton  bool      // 单一模式
 
+	pushInterval time.Duration // 推送覆盖率的间隔, 为 0 时不推送
+
+	coverReplaced bool // 是否覆盖本地 replace 的模块
+	overlay       bool // 是否使用 -overlay 原地构建
+
+	includePkgs []string // 插桩包含的包规则
+	excludePkgs []string // 插桩排除的包规则
+
 	goRunExecFlag  string // go run -exec flag
 	goRunArguments string // go run arguments
 )
@@ -30,6 +42,21 @@ var coverMode = CoverMode{
 	mode: "count",
 }
 
+// backend 插桩后端.
+var backend = Backend{
+	name: "goc",
+}
+
+// sourceCheck 覆盖率数据与本地源码的校验方式.
+var sourceCheck = SourceCheck{
+	action: "warn",
+}
+
+// outputFormat 管理命令的输出格式.
+var outputFormat = OutputFormat{
+	format: "table",
+}
+
 func addRunFlags(cmdset *pflag.FlagSet) {
 	addBuildFlags(cmdset)
 	cmdset.StringVar(&goRunExecFlag, "exec", "", "same as -exec flag in 'go run' command")
@@ -39,18 +66,74 @@ func addRunFlags(cmdset *pflag.FlagSet) {
 }
 func addBuildFlags(cmdset *pflag.FlagSet) {
 	addCommonFlags(cmdset)
+	cmdset.BoolVar(&coverReplaced, "cover-replaced", false, "cover the modules replaced by local directories in go.mod too")
+	cmdset.StringSliceVar(&includePkgs, "include-pkg", nil, "only instrument the packages matching these glob rules, or regular expressions prefixed with 're:', main packages always get the agent (default from 'cover.include_pkg' in the config file)")
+	cmdset.StringSliceVar(&excludePkgs, "exclude-pkg", nil, "do not instrument the packages matching these glob rules, or regular expressions prefixed with 're:' (default from 'cover.exclude_pkg' in the config file)")
+	cmdset.BoolVar(&overlay, "overlay", false, "build in place with 'go build -overlay' instead of copying the project to a temporary directory, only for go modules projects")
+	cmdset.Var(&backend, "backend", "instrumentation backend: goc, or native which builds with 'go build -cover' and converts the runtime/coverage counters in the atomic mode, not compatible with --overlay")
 	// bind to viper
 	viper.BindPFlags(cmdset)
 }
 func addCommonFlags(cmdset *pflag.FlagSet) {
 	addBasicFlags(cmdset)
-	cmdset.Var(&coverMode, "mode", "coverage mode: set, count, atomic")
+	cmdset.Var(&coverMode, "mode", "coverage mode: set, count, atomic, func which counts only the function entries, or branch which counts the outcomes of the conditions too, reported under the file names file.go:branch.true and file.go:branch.false which go tool cover does not accept, skip them with --skipfile ':branch\\.(true|false)$' for the standard tools")
 	cmdset.Var(&agentPort, "agentport", "a fixed port such as :8100 for registered service communicate with goc server. if not provided, using a random one")
 	cmdset.BoolVar(&singleton, "singleton", false, "singleton mode, not register to goc center")
+	cmdset.DurationVar(&pushInterval, "push-interval", 0, "push mode, push the profile to goc center on the interval such as 30s instead of registering the agent address, for services the center can not reach")
 	cmdset.StringVar(&buildFlags, "buildflags", "", "specify the build flags")
 	// bind to viper
 	viper.BindPFlags(cmdset)
 }
+
+// packageRules 返回插桩的包规则, 命令行未指定时使用配置文件中的规则.
+func packageRules(cmdset *pflag.FlagSet) (include, exclude []string) {
+	include, exclude = includePkgs, excludePkgs
+	if !cmdset.Changed("include-pkg") && rootViper != nil {
+		include = rootViper.GetStringSlice("cover.include_pkg")
+	}
+	if !cmdset.Changed("exclude-pkg") && rootViper != nil {
+		exclude = rootViper.GetStringSlice("cover.exclude_pkg")
+	}
+	return include, exclude
+}
+
+// coverCacheDir 返回插桩缓存目录, 未设置 --data-dir 时不使用缓存.
+func coverCacheDir() string {
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, "cover-cache")
+}
+
+// checkBackend 检查插桩后端, 推送模式与其他参数是否兼容.
+// go build -cover 不会插桩 -overlay 新增的文件, 所以 native 后端只能在临时目录中构建.
+func checkBackend() {
+	if singleton && pushInterval > 0 {
+		log.Fatalf("the singleton mode can not push the profile with --push-interval")
+	}
+	if !backend.Native() {
+		return
+	}
+	if overlay {
+		log.Fatalf("the native backend can not build with --overlay")
+	}
+	switch coverMode.String() {
+	case "func", "branch":
+		log.Fatalf("the %s mode is not supported by the native backend", coverMode.String())
+	}
+}
+
+// nativeCoverFlags 返回 native 后端的构建参数, runtime/coverage 只能在 atomic 模式下导出计数器.
+func nativeCoverFlags(packages []string) string {
+	flags := "-cover -covermode=atomic"
+	if len(packages) != 0 {
+		flags += " -coverpkg=" + strings.Join(packages, ",")
+	}
+	return flags
+}
+func addOutputFlags(cmdset *pflag.FlagSet) {
+	cmdset.VarP(&outputFormat, "format", "", "output format: table, json")
+}
 func addBasicFlags(cmdset *pflag.FlagSet) {
 	cmdset.StringVar(&center, "center", "http://127.0.0.1:7777", "cover profile host center")
 	// bind to viper
@@ -87,6 +170,9 @@ func (agent *AgentPort) Type() string {
 }
 
 // CoverMode struct 覆盖率检测模式.
+// func 模式只在函数入口计数, profile 中以 文件:函数名 作为文件名, 如 pkg/file.go:(*T).M.
+// branch 模式在 count 的基础上统计条件分支, 分支以 文件:branch.true 和 文件:branch.false 作为文件名,
+// go tool cover 等标准工具不认识这样的文件名, 交给它们之前需以 --skipfile ':branch\.(true|false)$' 去掉分支.
 type CoverMode struct {
 	mode string
 }
@@ -102,7 +188,7 @@ func (m *CoverMode) Set(v string) error {
 		m.mode = "count"
 		return nil
 	}
-	if v != "set" && v != "count" && v != "atomic" {
+	if v != "set" && v != "count" && v != "atomic" && v != "func" && v != "branch" {
 		return fmt.Errorf("unknown mode")
 	}
 	m.mode = v
@@ -113,3 +199,94 @@ func (m *CoverMode) Set(v string) error {
 func (m *CoverMode) Type() string {
 	return "string"
 }
+
+// OutputFormat struct 命令输出格式.
+type OutputFormat struct {
+	format string
+}
+
+// String method 返回输出格式字符串.
+func (o *OutputFormat) String() string {
+	return o.format
+}
+
+// Set method 设置输出格式.
+func (o *OutputFormat) Set(v string) error {
+	if v == "" {
+		o.format = "table"
+		return nil
+	}
+	if v != "table" && v != "json" {
+		return fmt.Errorf("unknown format")
+	}
+	o.format = v
+	return nil
+}
+
+// Type method 获取输出格式类型.
+func (o *OutputFormat) Type() string {
+	return "string"
+}
+
+// Backend struct 插桩后端.
+// goc 后端改写源码插入计数器, native 后端交给 go build -cover 插桩, 由注入的 agent 转换 runtime/coverage 的数据.
+type Backend struct {
+	name string
+}
+
+// String method 返回插桩后端名称.
+func (b *Backend) String() string {
+	return b.name
+}
+
+// Set method 设置插桩后端.
+func (b *Backend) Set(v string) error {
+	if v == "" {
+		b.name = "goc"
+		return nil
+	}
+	if v != "goc" && v != "native" {
+		return fmt.Errorf("unknown backend")
+	}
+	b.name = v
+	return nil
+}
+
+// Type method 获取插桩后端类型.
+func (b *Backend) Type() string {
+	return "string"
+}
+
+// Native method 是否使用 native 后端.
+func (b *Backend) Native() bool {
+	return b.name == "native"
+}
+
+// SourceCheck struct 校验覆盖率数据对应的源码与本地检出的源码是否一致.
+// off 不校验, warn 只打印不一致的文件, fail 存在不一致的文件时报错退出.
+type SourceCheck struct {
+	action string
+}
+
+// String method 返回校验方式字符串.
+func (c *SourceCheck) String() string {
+	return c.action
+}
+
+// Set method 设置校验方式.
+func (c *SourceCheck) Set(v string) error {
+	if v == "" {
+		c.action = "warn"
+		return nil
+	}
+	if v != "off" && v != "warn" && v != "fail" {
+		return fmt.Errorf("unknown source check")
+	}
+	c.action = v
+	return nil
+}
+
+// Type method 获取校验方式类型.
+func (c *SourceCheck) Type() string {
+	return "string"
+}
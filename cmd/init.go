@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+)
+
+// initCmd represents the init command.
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Clear the register information in order to start a new round of tests",
+	Long: `Clear all the services registered to the coverage center, including the persisted ones,
+in order to start a new round of tests.`,
+	Example: `
+# Clear the register information of the default register center http://127.0.0.1:7777.
+golangci-scope init
+
+# Clear the register information of the specified register center.
+golangci-scope init --center=http://192.168.1.1:8080
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if res, err := cover.NewWorker(center).InitSystem(); err != nil {
+			log.Fatalf("call host %v failed, err: %v, response: %v", center, err, string(res))
+		}
+		fmt.Printf("[goc] register information of %s cleared \n", center)
+	},
+}
+
+func init() {
+	addBasicFlags(initCmd.Flags())
+	rootCmd.AddCommand(initCmd)
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spelens-gud/golangci-scope/internal/build"
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+)
+
+// installCmd represents the install command.
+var installCmd = &cobra.Command{
+	Use:   "install [.|./...]",
+	Short: "Do cover for all go files and execute go install command",
+	Long: `Install command will copy the project code and its necessary dependencies to a temporary directory,
+then do cover for the main package in the current directory, or all main packages found for ./...,
+binaries will be installed to GOBIN, or GOPATH/bin if GOBIN is not set.`,
+	Example: `
+# Install all binaries with cover variables injected. The binary will be installed in $GOPATH/bin or $HOME/go/bin if directory existed.
+golangci-scope install ./...
+
+# Install the current binary with cover variables injected, and set the registry center to http://127.0.0.1:7777.
+golangci-scope install --center=http://127.0.0.1:7777
+
+# Install 'examples' package with cover variables injected, and set necessary build flags: -ldflags "-extldflags -static" -tags="embed kodo".
+golangci-scope install --buildflags="-ldflags '-extldflags -static' -tags='embed kodo'"
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		wd, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("Fail to install: %v", err)
+		}
+		includePkgs, excludePkgs = packageRules(cmd.Flags())
+		checkBackend()
+		runInstall(args, wd)
+	},
+}
+
+func init() {
+	addBuildFlags(installCmd.Flags())
+	rootCmd.AddCommand(installCmd)
+}
+
+func runInstall(args []string, wd string) {
+	gocBuild, err := build.NewInstall(buildFlags, args, wd, build.WithOverlay(overlay))
+	if err != nil {
+		log.Fatalf("Fail to install: %v", err)
+	}
+	// remove temporary directory if needed
+	defer gocBuild.Clean()
+	if coverReplaced {
+		if err := gocBuild.CpReplacedModules(); err != nil {
+			log.Fatalf("Fail to install: %v", err)
+		}
+	}
+
+	// execute covers for the target source with original buildFlags and new GOPATH( tmp:original )
+	ci := &cover.CoverInfo{
+		Args:                     buildFlags,
+		GoPath:                   gocBuild.NewGOPATH,
+		Target:                   gocBuild.SourceDir(),
+		Mode:                     coverMode.String(),
+		AgentPort:                agentPort.String(),
+		Center:                   center,
+		Singleton:                singleton,
+		PushInterval:             pushInterval,
+		IsMod:                    gocBuild.IsMod,
+		ModRootPath:              gocBuild.ModRootPath,
+		OneMainPackage:           false, // it is a go install, the selected main packages are instrumented in one pass
+		MainPackages:             gocBuild.MainPackages,
+		GlobalCoverVarImportPath: gocBuild.GlobalCoverVarImportPath,
+		GoWork:                   gocBuild.NewGOWORK,
+		ExtraPackages:            gocBuild.ExtraPackages,
+		OverlayFile:              gocBuild.OverlayFile,
+		CacheDir:                 coverCacheDir(),
+		IncludePackages:          includePkgs,
+		ExcludePackages:          excludePkgs,
+		Backend:                  backend.String(),
+	}
+	ci.Revision, ci.Modified = gocBuild.VCSRevision()
+	err = cover.Execute(ci)
+	if err != nil {
+		log.Fatalf("Fail to install: %v", err)
+	}
+	if backend.Native() {
+		gocBuild.CoverFlags = nativeCoverFlags(ci.CoverPackages)
+	}
+
+	if err := gocBuild.Install(); err != nil {
+		log.Fatalf("Fail to install: %v", err)
+	}
+	if err := gocBuild.WriteManifests(ci.Manifests); err != nil {
+		log.Fatalf("Fail to write the manifest: %v", err)
+	}
+}
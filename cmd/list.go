@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+)
+
+// listCmd represents the list command.
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists all the registered services",
+	Long: `Lists all the services registered to the coverage center, grouped by service name.
+With --health, the health of each instance probed by the center is listed too.`,
+	Example: `
+# List all the registered services from default register center http://127.0.0.1:7777.
+golangci-scope list
+
+# List all the registered services from specified register center, and output as json.
+golangci-scope list --center=http://192.168.1.1:8080 --format=json
+
+# List the registered services with their health.
+golangci-scope list --health
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if listHealth {
+			res, err := cover.NewWorker(center).ListServicesHealth()
+			if err != nil {
+				log.Fatalf("list failed, err: %v", err)
+			}
+
+			var health []cover.ServiceHealth
+			if err := json.Unmarshal(res, &health); err != nil {
+				log.Fatalf("failed to decode services health %q, err: %v", string(res), err)
+			}
+			if err := printServicesHealth(health); err != nil {
+				log.Fatalf("failed to print services health, err: %v", err)
+			}
+			return
+		}
+
+		res, err := cover.NewWorker(center).ListServices()
+		if err != nil {
+			log.Fatalf("list failed, err: %v", err)
+		}
+
+		var services map[string][]string
+		if err := json.Unmarshal(res, &services); err != nil {
+			log.Fatalf("failed to decode services %q, err: %v", string(res), err)
+		}
+		if err := printServices(services); err != nil {
+			log.Fatalf("failed to print services, err: %v", err)
+		}
+	},
+}
+
+var listHealth bool // --health flag
+
+func init() {
+	listCmd.Flags().BoolVar(&listHealth, "health", false, "list the health of each instance probed by the center")
+	addBasicFlags(listCmd.Flags())
+	addOutputFlags(listCmd.Flags())
+	rootCmd.AddCommand(listCmd)
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+// mergeCmd represents the merge command.
+var mergeCmd = &cobra.Command{
+	Use:   "merge [profiles or directories]",
+	Short: "Merge the profiles dumped by the instrumented binaries",
+	Long: `Merge command adds up the counters of the profiles into one profile.
+A directory stands for all the *.cov files in it, such as the profiles the instrumented binaries
+dump into GOC_COVER_DIR when they exit, when they get a termination signal, or on /v1/cover/flush.
+The exits of the main package through os.Exit and log.Fatal dump too, the ones in the other packages do not.
+The directory of GOC_COVER_DIR is merged if no argument is given.`,
+	Example: `
+# Run a short-lived binary several times, then merge the profiles they dumped.
+GOC_COVER_DIR=/tmp/cover ./cli-instrumented
+golangci-scope merge /tmp/cover --output=cover.out
+
+# Merge several profiles into stdout.
+golangci-scope merge a.cov b.cov
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			dir := os.Getenv("GOC_COVER_DIR")
+			if dir == "" {
+				log.Fatalf("no profile to merge, neither argument nor GOC_COVER_DIR is given")
+			}
+			args = []string{dir}
+		}
+		files, err := cover.ProfileFiles(args)
+		if err != nil {
+			log.Fatalf("failed to find the profiles, err: %v", err)
+		}
+		merged, err := cover.MergeProfileFiles(files)
+		if err != nil {
+			log.Fatalf("failed to merge the profiles, err: %v", err)
+		}
+
+		var f *os.File
+		if mergeOutput == "" {
+			f = os.Stdout
+		} else {
+			f, err = os.Create(mergeOutput)
+			if err != nil {
+				log.Fatalf("failed to create file %s, err: %v", mergeOutput, err)
+			}
+			defer f.Close()
+		}
+		if err := cov.DumpProfile(merged, f); err != nil {
+			log.Fatalf("failed to write profile to %s, err: %v", f.Name(), err)
+		}
+		if mergeOutput != "" {
+			fmt.Printf("[goc] %d profiles merged into %s \n", len(files), mergeOutput)
+		}
+	},
+}
+
+var mergeOutput string // --output flag
+
+func init() {
+	mergeCmd.Flags().StringVarP(&mergeOutput, "output", "o", "", "the merged profile, stdout if empty")
+	rootCmd.AddCommand(mergeCmd)
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"charm.land/lipgloss/v2"
+	"charm.land/lipgloss/v2/table"
+	"github.com/charmbracelet/x/exp/charmtone"
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	cov "golang.org/x/tools/cover"
+)
+
+var (
+	tableHeaderStyle = lipgloss.NewStyle().Foreground(charmtone.Hazy).Bold(true).Padding(0, 1)
+	tableCellStyle   = lipgloss.NewStyle().Padding(0, 1)
+	tableBorderStyle = lipgloss.NewStyle().Foreground(charmtone.Squid)
+)
+
+// printTable 以表格形式输出到标准输出.
+func printTable(headers []string, rows [][]string) {
+	t := table.New().
+		Border(lipgloss.RoundedBorder()).
+		BorderStyle(tableBorderStyle).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == table.HeaderRow {
+				return tableHeaderStyle
+			}
+			return tableCellStyle
+		}).
+		Headers(headers...).
+		Rows(rows...)
+	_, _ = lipgloss.Println(t)
+}
+
+// printJSON 以缩进的 json 形式输出到标准输出.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printServices 按输出格式打印已注册的服务.
+func printServices(services map[string][]string) error {
+	if outputFormat.String() == "json" {
+		return printJSON(services)
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := make([][]string, 0, len(services))
+	for _, name := range names {
+		for _, addr := range services[name] {
+			rows = append(rows, []string{name, addr})
+		}
+	}
+	printTable([]string{"SERVICE", "ADDRESS"}, rows)
+	return nil
+}
+
+// printServicesHealth 按输出格式打印服务实例的健康状态.
+func printServicesHealth(health []cover.ServiceHealth) error {
+	if outputFormat.String() == "json" {
+		return printJSON(health)
+	}
+
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+	rows := make([][]string, 0, len(health))
+	for _, h := range health {
+		rows = append(rows, []string{h.Name, h.Address, h.Status, formatTime(h.LastSeen), formatTime(h.UnhealthySince), h.Error})
+	}
+	printTable([]string{"SERVICE", "ADDRESS", "STATUS", "LAST SEEN", "UNHEALTHY SINCE", "ERROR"}, rows)
+	return nil
+}
+
+// printServiceResults 按输出格式打印对服务的操作结果.
+func printServiceResults(raw []byte) error {
+	var results []cover.ServiceResult
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return fmt.Errorf("failed to decode response %q, err: %w", string(raw), err)
+	}
+	if outputFormat.String() == "json" {
+		return printJSON(results)
+	}
+
+	rows := make([][]string, 0, len(results))
+	for _, r := range results {
+		rows = append(rows, []string{r.Name, r.Address, r.Result})
+	}
+	printTable([]string{"SERVICE", "ADDRESS", "RESULT"}, rows)
+	return nil
+}
+
+// printDiffCoverage 按输出格式打印变更行的覆盖率.
+func printDiffCoverage(d *cover.DiffCoverage) error {
+	if outputFormat.String() == "json" {
+		return printJSON(d)
+	}
+
+	rows := make([][]string, 0, len(d.Files)+1)
+	for _, f := range d.Files {
+		rows = append(rows, []string{
+			f.File,
+			fmt.Sprintf("%d/%d", len(f.Covered), len(f.Covered)+len(f.Uncovered)),
+			cover.LineRanges(f.Uncovered),
+		})
+	}
+	rows = append(rows, []string{"TOTAL", fmt.Sprintf("%d/%d (%.1f%%)", d.Covered, d.Covered+d.Uncovered, d.Percent()), ""})
+	printTable([]string{"FILE", "COVERED", "UNCOVERED LINES"}, rows)
+	return nil
+}
+
+// fileCoverage 是单个文件的语句覆盖率.
+type fileCoverage struct {
+	File       string `json:"file"`
+	Statements int    `json:"statements"`
+	Covered    int    `json:"covered"`
+	// 分支的结果数, 只在 branch 模式下输出
+	Branches        int `json:"branches,omitempty"`
+	CoveredBranches int `json:"covered_branches,omitempty"`
+}
+
+// printFileCoverage 按输出格式打印每个文件的语句覆盖率, branch 模式下同时打印分支覆盖率.
+func printFileCoverage(profiles []*cov.Profile) error {
+	var files []fileCoverage
+	var total cover.Summary
+	branches := cover.SourceBranches(profiles)
+	for _, p := range cover.SourceProfiles(profiles) {
+		s := cover.Summarize([]*cov.Profile{p})
+		bs := cover.SummarizeBranches(branches[p.FileName])
+		files = append(files, fileCoverage{File: p.FileName, Statements: s.Statements, Covered: s.CoveredStatements, Branches: bs.Branches, CoveredBranches: bs.CoveredBranches})
+		total.Statements += s.Statements
+		total.CoveredStatements += s.CoveredStatements
+		total.Branches += bs.Branches
+		total.CoveredBranches += bs.CoveredBranches
+	}
+	if outputFormat.String() == "json" {
+		return printJSON(files)
+	}
+
+	// 只有 branch 模式的 profile 才有分支覆盖率的列
+	row := func(name string, covered, statements, coveredBranches, branches int) []string {
+		r := []string{name, fmt.Sprintf("%d/%d", covered, statements), cover.Percent(covered, statements)}
+		if total.Branches != 0 {
+			r = append(r, fmt.Sprintf("%d/%d", coveredBranches, branches), cover.Percent(coveredBranches, branches))
+		}
+		return r
+	}
+	headers := []string{"FILE", "STATEMENTS", "COVERAGE"}
+	if total.Branches != 0 {
+		headers = append(headers, "BRANCHES", "BRANCH COVERAGE")
+	}
+	rows := make([][]string, 0, len(files)+1)
+	for _, f := range files {
+		rows = append(rows, row(f.File, f.Covered, f.Statements, f.CoveredBranches, f.Branches))
+	}
+	rows = append(rows, row("TOTAL", total.CoveredStatements, total.Statements, total.CoveredBranches, total.Branches))
+	printTable(headers, rows)
+	return nil
+}
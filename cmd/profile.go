@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+)
+
+// profileCmd represents the profile command.
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Get coverage profile from service registry center",
+	Long: `Get code coverage profile for the services under test at runtime.
+The profiles of all the selected services are merged by the center into one profile.`,
+	Example: `
+# Get coverage counter from default register center http://127.0.0.1:7777, the result output to stdout.
+golangci-scope profile
+
+# Get coverage counter from specified register center, the result output to specified file.
+golangci-scope profile --center=http://192.168.1.1:8080 --output=./coverage.cov
+
+# Get coverage counter of several specified services. You can get all available service names from command 'golangci-scope list'. Use 'service' and 'address' flag at the same time may cause ambiguity, please use them separately.
+golangci-scope profile --service=service1,service2,service3
+
+# Get coverage counter of several specified addresses. You can get all available addresses from command 'golangci-scope list'. Use 'service' and 'address' flag at the same time may cause ambiguity, please use them separately.
+golangci-scope profile --address=address1,address2,address3
+
+# Only get the coverage data of files matching the special patterns
+golangci-scope profile --coverfile=pattern1,pattern2,pattern3
+
+# Skip the coverage data of files matching the special patterns
+golangci-scope profile --skipfile=pattern1,pattern2,pattern3
+
+# Force fetching all available profiles.
+golangci-scope profile --force
+
+# Refuse the profile if the instrumented source differs from the checkout in the current directory.
+golangci-scope profile --check-source=fail
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := cover.ProfileParam{
+			Force:             force,
+			Service:           svrList,
+			Address:           addrList,
+			CoverFilePatterns: coverFilePatterns,
+			SkipFilePatterns:  skipFilePatterns,
+		}
+		res, err := cover.NewWorker(center).Profile(p)
+		if err != nil {
+			log.Fatalf("Failed to get profile from goc server %s, err: %v", center, err)
+		}
+		checkProfileSources(res, p, ".")
+
+		var f *os.File
+		if profileOutput == "" {
+			f = os.Stdout
+		} else {
+			f, err = os.Create(profileOutput)
+			if err != nil {
+				log.Fatalf("failed to create file %s, err: %v", profileOutput, err)
+			}
+			defer f.Close()
+		}
+		_, err = io.Copy(f, bytes.NewReader(res))
+		if err != nil {
+			log.Fatalf("failed to write profile to %s, err: %v", f.Name(), err)
+		}
+
+		// report the branch coverage alongside the statement coverage for the profiles of branch mode
+		if summary, err := cover.SummarizeProfile(res); err == nil && summary.Branches != 0 {
+			fmt.Fprintf(os.Stderr, "[goc] %v\n", summary)
+		}
+	},
+}
+
+// checkProfileSources compares the instrumented source of the profile with the checkout containing dir,
+// the mismatched files are reported, and refused with --check-source=fail
+func checkProfileSources(profile []byte, p cover.ProfileParam, dir string) []cover.Mismatch {
+	if sourceCheck.String() == "off" {
+		return nil
+	}
+	fail := func(format string, args ...interface{}) {
+		if sourceCheck.String() == "fail" {
+			log.Fatalf(format, args...)
+		}
+		fmt.Fprintf(os.Stderr, "[goc] warning: "+format+"\n", args...)
+	}
+	checkout, err := cover.FindCheckout(dir)
+	if err != nil {
+		fail("can not check the source of the profile: %v", err)
+		return nil
+	}
+	res, err := cover.NewWorker(center).Manifest(p)
+	if err != nil {
+		fail("can not get the source hashes from goc server %s: %v", center, err)
+		return nil
+	}
+	var hashes cover.SourceHashes
+	if err := json.Unmarshal(res, &hashes); err != nil {
+		fail("invalid source hashes from goc server %s: %v", center, err)
+		return nil
+	}
+	files, err := cover.ProfileSourceFiles(profile)
+	if err != nil {
+		fail("invalid profile: %v", err)
+		return nil
+	}
+	mismatches := checkout.CheckSources(files, &hashes)
+	if len(mismatches) == 0 {
+		return nil
+	}
+	for _, m := range mismatches {
+		fmt.Fprintf(os.Stderr, "[goc] source mismatch: %v\n", m)
+	}
+	fail("%d files of the profile do not match the checkout in %s", len(mismatches), checkout.Root)
+	return mismatches
+}
+
+var (
+	svrList           []string // --service flag
+	addrList          []string // --address flag
+	force             bool     // --force flag
+	profileOutput     string   // --output flag
+	coverFilePatterns []string // --coverfile flag
+	skipFilePatterns  []string // --skipfile flag
+)
+
+func init() {
+	profileCmd.Flags().StringVarP(&profileOutput, "output", "o", "", "download cover profile")
+	profileCmd.Flags().StringSliceVarP(&svrList, "service", "", nil, "service name to fetch profile, see 'golangci-scope list' for all services.")
+	profileCmd.Flags().StringSliceVarP(&addrList, "address", "", nil, "address to fetch profile, see 'golangci-scope list' for all addresses.")
+	profileCmd.Flags().BoolVarP(&force, "force", "f", false, "force fetching all available profiles")
+	profileCmd.Flags().StringSliceVarP(&coverFilePatterns, "coverfile", "", nil, "only output coverage data of the files matching the patterns")
+	profileCmd.Flags().StringSliceVarP(&skipFilePatterns, "skipfile", "", nil, "skip the files matching the patterns when outputting coverage data")
+	profileCmd.Flags().Var(&sourceCheck, "check-source", "check the instrumented source of the profile against the checkout in the current directory: off, warn, or fail which refuses the mismatched profile")
+	addBasicFlags(profileCmd.Flags())
+	rootCmd.AddCommand(profileCmd)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+)
+
+// removeCmd represents the remove command.
+var removeCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove the specified services from the register center",
+	Long: `Remove the specified services from the register center, after that, the removed services
+are no longer involved in collecting coverage profiles.`,
+	Example: `
+# Remove the service 'mongo' from the default register center http://127.0.0.1:7777.
+golangci-scope remove --service=mongo
+
+# Remove the service 'http://127.0.0.1:53' from the specified register center.
+golangci-scope remove --address=http://127.0.0.1:53 --center=http://192.168.1.1:8080
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(svrList) == 0 && len(addrList) == 0 {
+			log.Fatalf("at least one of 'service' and 'address' flag should be provided")
+		}
+		p := cover.ProfileParam{
+			Service: svrList,
+			Address: addrList,
+		}
+		res, err := cover.NewWorker(center).Remove(p)
+		if err != nil {
+			log.Fatalf("call host %v failed, err: %v, response: %v", center, err, string(res))
+		}
+		if err := printServiceResults(res); err != nil {
+			log.Fatalf("failed to print result, err: %v", err)
+		}
+	},
+}
+
+func init() {
+	removeCmd.Flags().StringSliceVarP(&svrList, "service", "", nil, "service name to remove, see 'golangci-scope list' for all services.")
+	removeCmd.Flags().StringSliceVarP(&addrList, "address", "", nil, "address to remove, see 'golangci-scope list' for all addresses.")
+	addBasicFlags(removeCmd.Flags())
+	addOutputFlags(removeCmd.Flags())
+	rootCmd.AddCommand(removeCmd)
+}
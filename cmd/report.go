@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+	cov "golang.org/x/tools/cover"
+)
+
+// reportCmd represents the report command.
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report the coverage of each file, or render it as annotated sources in html",
+	Long: `Report command gets the merged profile from the service registry center, or reads it from --profile,
+and reports the statement coverage of each file.
+With --html, the sources in the module checkout given by --source-root are annotated with the coverage,
+the hit counts of count and atomic modes are shown as heat intensity.`,
+	Example: `
+# Report the coverage of each file of the services registered to the default center http://127.0.0.1:7777.
+golangci-scope report
+
+# Render the coverage of the specified services as annotated sources into coverage.html.
+golangci-scope report --html --service=service1,service2
+
+# Render a local profile with the sources in /data/src into /tmp/cover.html.
+golangci-scope report --html --profile=cover.out --source-root=/data/src --output=/tmp/cover.html
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var res []byte
+		var mismatches []cover.Mismatch
+		if reportProfile != "" {
+			content, err := os.ReadFile(reportProfile)
+			if err != nil {
+				log.Fatalf("failed to read profile %s, err: %v", reportProfile, err)
+			}
+			res = content
+		} else {
+			p := cover.ProfileParam{
+				Force:             force,
+				Service:           svrList,
+				Address:           addrList,
+				CoverFilePatterns: coverFilePatterns,
+				SkipFilePatterns:  skipFilePatterns,
+			}
+			content, err := cover.NewWorker(center).Profile(p)
+			if err != nil {
+				log.Fatalf("Failed to get profile from goc server %s, err: %v", center, err)
+			}
+			res = content
+			mismatches = checkProfileSources(res, p, reportSourceRoot)
+		}
+		profiles, err := cov.ParseProfilesFromReader(bytes.NewReader(res))
+		if err != nil {
+			log.Fatalf("failed to parse profile, err: %v", err)
+		}
+
+		if !reportHTML {
+			if err := printFileCoverage(profiles); err != nil {
+				log.Fatalf("failed to print the coverage, err: %v", err)
+			}
+			return
+		}
+		checkout, err := cover.FindCheckout(reportSourceRoot)
+		if err != nil {
+			log.Fatalf("failed to find the module in %s, err: %v", reportSourceRoot, err)
+		}
+		f, err := os.Create(reportOutput)
+		if err != nil {
+			log.Fatalf("failed to create file %s, err: %v", reportOutput, err)
+		}
+		defer f.Close()
+		if err := cover.RenderHTML(f, profiles, checkout, mismatches); err != nil {
+			log.Fatalf("failed to render the report, err: %v", err)
+		}
+		fmt.Printf("[goc] coverage report generated: %s \n", reportOutput)
+	},
+}
+
+var (
+	reportHTML       bool   // --html flag
+	reportProfile    string // --profile flag
+	reportSourceRoot string // --source-root flag
+	reportOutput     string // --output flag
+)
+
+func init() {
+	reportCmd.Flags().BoolVar(&reportHTML, "html", false, "render the coverage as annotated sources in html")
+	reportCmd.Flags().StringVar(&reportProfile, "profile", "", "read the profile from the file instead of the center")
+	reportCmd.Flags().StringVar(&reportSourceRoot, "source-root", ".", "the module checkout the sources are read from")
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", "coverage.html", "the html report file")
+	reportCmd.Flags().StringSliceVarP(&svrList, "service", "", nil, "service name to fetch profile, see 'golangci-scope list' for all services.")
+	reportCmd.Flags().StringSliceVarP(&addrList, "address", "", nil, "address to fetch profile, see 'golangci-scope list' for all addresses.")
+	reportCmd.Flags().BoolVarP(&force, "force", "f", false, "force fetching all available profiles")
+	reportCmd.Flags().StringSliceVarP(&coverFilePatterns, "coverfile", "", nil, "only report the files matching the patterns")
+	reportCmd.Flags().StringSliceVarP(&skipFilePatterns, "skipfile", "", nil, "skip the files matching the patterns")
+	reportCmd.Flags().Var(&sourceCheck, "check-source", "check the instrumented source of the profile against the source root: off, warn, or fail which refuses the mismatched profile")
+	addBasicFlags(reportCmd.Flags())
+	addOutputFlags(reportCmd.Flags())
+	rootCmd.AddCommand(reportCmd)
+}
@@ -139,7 +139,7 @@ func init() {
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "run programe in debug mode")
 	rootCmd.PersistentFlags().StringVar(&debugInCISyncFile, "debugcisyncfile", "", "ci sync file")
 	rootCmd.PersistentFlags().StringVar(&cwd, "cwd", "", "Current working directory")
-	rootCmd.PersistentFlags().StringVar(&dataDir, "data-dir", "", "Custom crush data directory")
+	rootCmd.PersistentFlags().StringVar(&dataDir, "data-dir", "", "Custom data directory, the instrumentation cache is kept in it")
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "配置文件路径 (默认为 ./config/default.yaml)")
 	rootCmd.Flags().BoolVar(&help, "help", false, "Help")
 	assert.MustCall1E(viper.BindPFlags, rootCmd.PersistentFlags(), "viper 隐藏失败")
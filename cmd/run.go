@@ -33,13 +33,20 @@ to quickly create a Cobra application.`,
 		if err != nil {
 			log.Fatalf("Fail to build: %v", err)
 		}
-		gocBuild, err := build.NewBuild(buildFlags, args, wd, buildOutput)
+		includePkgs, excludePkgs = packageRules(cmd.Flags())
+		checkBackend()
+		gocBuild, err := build.NewRun(buildFlags, args, wd, build.WithOverlay(overlay))
 		if err != nil {
 			log.Fatalf("Fail to run: %v", err)
 		}
 		gocBuild.GoRunExecFlag = goRunExecFlag
 		gocBuild.GoRunArguments = goRunArguments
 		defer gocBuild.Clean()
+		if coverReplaced {
+			if err := gocBuild.CpReplacedModules(); err != nil {
+				log.Fatalf("Fail to run: %v", err)
+			}
+		}
 
 		server := cover.NewMemoryBasedServer() // only save services in memory
 
@@ -62,20 +69,33 @@ to quickly create a Cobra application.`,
 		ci := &cover.CoverInfo{
 			Args:                     buildFlags,
 			GoPath:                   gocBuild.NewGOPATH,
-			Target:                   gocBuild.TmpDir,
+			Target:                   gocBuild.SourceDir(),
 			Mode:                     coverMode.String(),
 			Center:                   gocServer,
 			Singleton:                singleton,
+			PushInterval:             pushInterval,
 			AgentPort:                "",
 			IsMod:                    gocBuild.IsMod,
 			ModRootPath:              gocBuild.ModRootPath,
 			OneMainPackage:           true, // go run is similar with go build, build only one main package
+			MainPackages:             gocBuild.MainPackages,
 			GlobalCoverVarImportPath: gocBuild.GlobalCoverVarImportPath,
+			GoWork:                   gocBuild.NewGOWORK,
+			ExtraPackages:            gocBuild.ExtraPackages,
+			OverlayFile:              gocBuild.OverlayFile,
+			CacheDir:                 coverCacheDir(),
+			IncludePackages:          includePkgs,
+			ExcludePackages:          excludePkgs,
+			Backend:                  backend.String(),
 		}
+		ci.Revision, ci.Modified = gocBuild.VCSRevision()
 		err = cover.Execute(ci)
 		if err != nil {
 			log.Fatalf("Fail to run: %v", err)
 		}
+		if backend.Native() {
+			gocBuild.CoverFlags = nativeCoverFlags(ci.CoverPackages)
+		}
 
 		if err := gocBuild.Run(); err != nil {
 			log.Fatalf("Fail to run: %v", err)
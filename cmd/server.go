@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spf13/cobra"
+)
+
+// serverCmd represents the server command.
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Start a service registry center",
+	Long: `Start a service registry center, instrumented services register themselves to it,
+and it collects coverage profiles from them.
+
+The registered services are persisted into the store file, and the profiles pushed by the services
+into the directory <store-file>.pushed, so they survive a restart of the center.
+The center probes the registered services, and removes the ones unhealthy for longer than --evict-after,
+such as the ones crashed without deregistering. The pushing instances missing their pushes for longer than it
+are merged into one expired profile of their service.
+With a source root, the center renders the merged profile as annotated sources at /v1/cover/report.`,
+	Example: `
+# Start a service registry center, default port :7777.
+golangci-scope server
+
+# Start a service registry center with port :8080.
+golangci-scope server --port=:8080
+
+# Start a service registry center with localhost:8080, and save the registered services to /data/services.txt.
+golangci-scope server --port=localhost:8080 --store-file=/data/services.txt
+
+# Start a service registry center serving the html reports at /v1/cover/report, with the sources of the checkout in /data/src.
+golangci-scope server --source-root=/data/src
+
+# Start a service registry center probing the services every 30 seconds, and removing the ones unhealthy for 10 minutes.
+golangci-scope server --probe-interval=30s --evict-after=10m
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		server, err := cover.NewFileBasedServer(serverStoreFile)
+		if err != nil {
+			log.Fatalf("New file based server failed, err: %v", err)
+		}
+		server.IPRevise = serverIPRevise
+		server.SourceRoot = serverSourceRoot
+		if !cmd.Flags().Changed("source-root") && rootViper != nil {
+			server.SourceRoot = rootViper.GetString("server.source_root")
+		}
+		server.ProbeInterval, server.EvictAfter = serverProbeInterval, serverEvictAfter
+		if !cmd.Flags().Changed("probe-interval") && rootViper != nil && rootViper.IsSet("server.probe_interval") {
+			server.ProbeInterval = rootViper.GetDuration("server.probe_interval")
+		}
+		if !cmd.Flags().Changed("evict-after") && rootViper != nil && rootViper.IsSet("server.evict_after") {
+			server.EvictAfter = rootViper.GetDuration("server.evict_after")
+		}
+		if err := server.Run(serverPort); err != nil {
+			log.Fatalf("goc server failed to run, err: %v", err)
+		}
+	},
+}
+
+var (
+	serverPort      string // 覆盖率中心监听端口
+	serverStoreFile string // 服务注册信息持久化文件
+	serverIPRevise  bool   // 注册时是否修正服务 ip
+
+	serverSourceRoot string // 覆盖率报告读取源码的目录
+
+	serverProbeInterval time.Duration // 探测已注册服务的间隔
+	serverEvictAfter    time.Duration // 服务不健康超过该时长后移除
+)
+
+func init() {
+	serverCmd.Flags().StringVar(&serverPort, "port", ":7777", "listen port to start a coverage host center")
+	serverCmd.Flags().StringVar(&serverStoreFile, "store-file", "_svrs_address.txt", "the file to save services address information")
+	serverCmd.Flags().BoolVar(&serverIPRevise, "ip-revise", true, "whether to do ip revise during registering")
+	serverCmd.Flags().StringVar(&serverSourceRoot, "source-root", "", "the module checkout the html reports read the sources from, no reports if empty (default from 'server.source_root' in the config file)")
+	serverCmd.Flags().DurationVar(&serverProbeInterval, "probe-interval", 10*time.Second, "the interval of probing the registered services, 0 to disable (default from 'server.probe_interval' in the config file)")
+	serverCmd.Flags().DurationVar(&serverEvictAfter, "evict-after", 5*time.Minute, "remove the services unhealthy for longer than the grace period, 0 to keep them (default from 'server.evict_after' in the config file)")
+	rootCmd.AddCommand(serverCmd)
+}
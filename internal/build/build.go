@@ -2,7 +2,10 @@ package build
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/spelens-gud/golangci-scope/internal/cover"
@@ -35,24 +38,45 @@ type Build struct {
 	GoRunExecFlag  string // for the -exec flags in go run command
 	GoRunArguments string // for the '[arguments]' parameters in go run command
 
-	OneMainPackage           bool   // whether this build is a go build or go install? true: build, false: install
-	GlobalCoverVarImportPath string // Importpath for storing cover variables
-	GlobalCoverVarFilePath   string // Importpath for storing cover variables
+	OneMainPackage           bool     // whether this build is a go build or go install? true: build, false: install
+	MainPackages             []string // import paths of the main packages selected by Packages
+	GoWorkFile               string   // the go.work file used by the project, empty if not in workspace mode
+	NewGOWORK                string   // the go.work file generated in the temporary directory
+	ExtraPackages            []string // package patterns of the other modules to cover, relative to TmpDir
+	UseOverlay               bool     // build the project in place with -overlay instead of copying it
+	OverlayFile              string   // the -overlay file generated in the temporary directory
+	CoverFlags               string   // the -cover flags of the native backend, the toolchain instruments the project if set
+	GlobalCoverVarImportPath string   // Importpath for storing cover variables
+	GlobalCoverVarFilePath   string   // Importpath for storing cover variables
 }
 
-func NewBuild(buildflags string, args []string, workingDir string, outputDir string) (*Build, error) {
+// Option configures how a Build prepares the project
+type Option func(*Build)
+
+// WithOverlay makes the Build instrument the project into a scratch directory
+// and build it with 'go build -overlay', the source tree is never copied or modified
+func WithOverlay(overlay bool) Option {
+	return func(b *Build) {
+		b.UseOverlay = overlay
+	}
+}
+
+func NewBuild(buildflags string, args []string, workingDir string, outputDir string, opts ...Option) (*Build, error) {
 	if err := checkParameters(args, workingDir); err != nil {
 		return nil, err
 	}
 	// buildflags = buildflags + " -o " + outputDir
 	b := &Build{
-		BuildFlags: buildflags,
-		Packages:   strings.Join(args, " "),
-		WorkingDir: workingDir,
+		BuildFlags:     buildflags,
+		Packages:       strings.Join(args, " "),
+		WorkingDir:     workingDir,
+		OneMainPackage: true,
 	}
-	if false == b.validatePackageForBuild() {
-		logger.Error(ErrWrongPackageTypeForBuild.Error())
-		return nil, ErrWrongPackageTypeForBuild
+	for _, opt := range opts {
+		opt(b)
+	}
+	if err := b.validatePackageForBuild(); err != nil {
+		return nil, err
 	}
 	if err := b.MvProjectsToTmp(); err != nil {
 		return nil, err
@@ -65,11 +89,81 @@ func NewBuild(buildflags string, args []string, workingDir string, outputDir str
 	return b, nil
 }
 
+// NewRun creates a Build struct which can run the only main package from goc temporary directory
+func NewRun(buildflags string, args []string, workingDir string, opts ...Option) (*Build, error) {
+	if len(args) > 1 {
+		logger.Error(ErrTooManyArgs.Error())
+		return nil, ErrTooManyArgs
+	}
+	if err := checkParameters(args, workingDir); err != nil {
+		return nil, err
+	}
+	b := &Build{
+		BuildFlags:     buildflags,
+		Packages:       strings.Join(args, " "),
+		WorkingDir:     workingDir,
+		OneMainPackage: true,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.Packages == "" {
+		b.Packages = "."
+	}
+	if err := b.validatePackageForBuild(); err != nil {
+		return nil, err
+	}
+	if len(b.MainPackages) != 1 {
+		logger.Errorf("%v, got: %v", ErrTooManyMainPackagesForRun, b.MainPackages)
+		return nil, ErrTooManyMainPackagesForRun
+	}
+	if err := b.MvProjectsToTmp(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Build calls 'go build' tool to do building
+func (b *Build) Build() error {
+	logger.Info("Go building in temp...")
+	// new -o will overwrite previous ones
+	buildFlags := b.goBuildFlags() + " -o " + b.Target
+	cmd := exec.Command("/bin/bash", "-c", "go build "+buildFlags+" "+b.Packages)
+	cmd.Dir = b.TmpWorkingDir
+
+	cmd.Env = b.goEnv()
+
+	logger.Infof("go build cmd is: %v", cmd.Args)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fail to execute: %v, err: %w, output: %s", cmd.Args, err, string(out))
+	}
+	logger.Infof("Go build exit successful, binary generated in: %v", b.Target)
+	return nil
+}
+
 func (b *Build) determineOutputDir(outputDir string) (string, error) {
 	if b.TmpDir == "" {
 		return "", fmt.Errorf("can only be called after Build.MvProjectsToTmp(): %w", ErrEmptyTempWorkingDir)
 	}
 
+	// several main packages, the output is a directory holding all the binaries
+	if len(b.MainPackages) > 1 {
+		dir := b.WorkingDir
+		if outputDir != "" {
+			abs, err := filepath.Abs(outputDir)
+			if err != nil {
+				return "", fmt.Errorf("Fail to transform the path: %v to absolute path: %v", outputDir, err)
+			}
+			dir = abs
+		}
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return "", fmt.Errorf("Fail to create the output directory: %v, err: %v", dir, err)
+		}
+		// the trailing separator forces go build to write binaries into the directory
+		return dir + string(filepath.Separator), nil
+	}
+
 	// fix #43
 	if outputDir != "" {
 		abs, err := filepath.Abs(outputDir)
@@ -80,10 +174,10 @@ func (b *Build) determineOutputDir(outputDir string) (string, error) {
 		return abs, nil
 	}
 	// fix #43
-	// use target name from `go list -json ./...` of the main module
+	// use target name from `go list -json` of the selected main package
 	targetName := ""
 	for _, pkg := range b.Pkgs {
-		if pkg.Name == "main" {
+		if pkg.Name == "main" && (len(b.MainPackages) == 0 || pkg.ImportPath == b.MainPackages[0]) {
 			if pkg.Target != "" {
 				targetName = filepath.Base(pkg.Target)
 			} else {
@@ -96,19 +190,35 @@ func (b *Build) determineOutputDir(outputDir string) (string, error) {
 	return filepath.Join(b.WorkingDir, targetName), nil
 }
 
-// validatePackageForBuild only allow . as package name
-func (b *Build) validatePackageForBuild() bool {
-	if b.Packages == "." || b.Packages == "" {
-		return true
+// validatePackageForBuild resolves the package patterns, at least one main package should be selected,
+// the packages which are not main are ignored by go build just like without goc
+func (b *Build) validatePackageForBuild() error {
+	listArgs := []string{"-json"}
+	if len(b.BuildFlags) != 0 {
+		listArgs = append(listArgs, b.BuildFlags)
 	}
-	return false
-}
-func checkParameters(args []string, workingDir string) error {
-	if len(args) > 1 {
-		logger.Error(ErrTooManyArgs.Error())
-		return ErrTooManyArgs
+	listArgs = append(listArgs, b.Packages)
+	pkgs, err := cover.ListPackages(b.WorkingDir, strings.Join(listArgs, " "), "")
+	if err != nil {
+		logger.Error(err.Error())
+		return err
 	}
 
+	b.MainPackages = nil
+	for importPath, pkg := range pkgs {
+		if pkg.Name == "main" {
+			b.MainPackages = append(b.MainPackages, importPath)
+		}
+	}
+	sort.Strings(b.MainPackages)
+	if len(b.MainPackages) == 0 {
+		logger.Error(ErrWrongPackageTypeForBuild.Error())
+		return ErrWrongPackageTypeForBuild
+	}
+	logger.Infof("main packages to build: %v", b.MainPackages)
+	return nil
+}
+func checkParameters(args []string, workingDir string) error {
 	if workingDir == "" {
 		return ErrInvalidWorkingDir
 	}
@@ -0,0 +1,174 @@
+package build
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+)
+
+// buildProject builds the main package in dir with cover variables injected the way the build command does
+func buildProject(t *testing.T, dir string, backend string) string {
+	t.Helper()
+	b, err := NewBuild("", []string{"."}, dir, filepath.Join(dir, "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Clean()
+	ci := &cover.CoverInfo{
+		Target:                   b.SourceDir(),
+		Mode:                     "count",
+		IsMod:                    b.IsMod,
+		ModRootPath:              b.ModRootPath,
+		OneMainPackage:           true,
+		MainPackages:             b.MainPackages,
+		GlobalCoverVarImportPath: b.GlobalCoverVarImportPath,
+		Backend:                  backend,
+	}
+	if backend == "native" {
+		ci.Mode = "atomic"
+	}
+	if err := cover.Execute(ci); err != nil {
+		t.Fatal(err)
+	}
+	if backend == "native" {
+		b.CoverFlags = "-cover -covermode=atomic -coverpkg=" + strings.Join(ci.CoverPackages, ",")
+	}
+	if err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Target
+}
+
+func TestBuildDumpsProfileOnExit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a program")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	// the project is not part of the workspace the tests may run in
+	t.Setenv("GOWORK", "off")
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/exit\n\ngo 1.21\n",
+		"main.go": `package main
+
+import (
+	"log"
+	"os"
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		log.Fatalf("fatal: %s", os.Args[1])
+	}
+	os.Exit(3)
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, backend := range []string{"goc", "native"} {
+		t.Run(backend, func(t *testing.T) {
+			app := buildProject(t, dir, backend)
+			for _, tt := range []struct {
+				name string
+				args []string
+				code int
+			}{
+				{"os.Exit", nil, 3},
+				{"log.Fatalf", []string{"x"}, 1},
+			} {
+				coverDir := t.TempDir()
+				cmd := exec.Command(app, tt.args...)
+				cmd.Dir = t.TempDir()
+				cmd.Env = append(os.Environ(), "GOC_COVER_DIR="+coverDir, "GOCOVERDIR="+t.TempDir())
+				err := cmd.Run()
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) || exitErr.ExitCode() != tt.code {
+					t.Fatalf("%s: got %v, want the exit code %d", tt.name, err, tt.code)
+				}
+				dumps, err := filepath.Glob(filepath.Join(coverDir, "*.cov"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(dumps) != 1 {
+					t.Fatalf("%s: got the dumps %v, want one", tt.name, dumps)
+				}
+			}
+		})
+	}
+}
+
+func TestInstallSelectsMainPackages(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	t.Setenv("GOWORK", "off")
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":           "module example.com/install\n\ngo 1.21\n",
+		"main.go":          "package main\n\nfunc main() {}\n",
+		"cmd/tool/main.go": "package main\n\nfunc main() {}\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args     []string
+		main     []string // the selected main packages
+		injected []string // the directories of the main packages the agent is injected into
+	}{
+		{nil, []string{"example.com/install"}, []string{"."}},
+		{[]string{"."}, []string{"example.com/install"}, []string{"."}},
+		{[]string{"./..."}, nil, []string{".", "cmd/tool"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			b, err := NewInstall("", tt.args, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Clean()
+			if !reflect.DeepEqual(b.MainPackages, tt.main) {
+				t.Errorf("main packages %v, want %v", b.MainPackages, tt.main)
+			}
+			ci := &cover.CoverInfo{
+				Target:                   b.SourceDir(),
+				Mode:                     "count",
+				IsMod:                    b.IsMod,
+				ModRootPath:              b.ModRootPath,
+				MainPackages:             b.MainPackages,
+				GlobalCoverVarImportPath: b.GlobalCoverVarImportPath,
+			}
+			if err := cover.Execute(ci); err != nil {
+				t.Fatal(err)
+			}
+			var injected []string
+			for _, pkgDir := range []string{".", "cmd/tool"} {
+				if _, err := os.Stat(filepath.Join(b.SourceDir(), pkgDir, "http_cover_apis_auto_generated.go")); err == nil {
+					injected = append(injected, pkgDir)
+				}
+			}
+			if !reflect.DeepEqual(injected, tt.injected) {
+				t.Errorf("agent injected into %v, want %v", injected, tt.injected)
+			}
+		})
+	}
+}
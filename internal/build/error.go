@@ -9,14 +9,18 @@ var (
 	ErrGocShouldExecInProject = errors.New("goc not support for such project directory")
 	// ErrWrongPackageTypeForInstall represents goc install command only support limited arguments
 	ErrWrongPackageTypeForInstall = errors.New("packages only support \".\" and \"./...\"")
-	// ErrWrongPackageTypeForBuild represents goc build command only support limited arguments
-	ErrWrongPackageTypeForBuild = errors.New("packages only support \".\"")
+	// ErrWrongPackageTypeForBuild represents goc build command needs main packages to build
+	ErrWrongPackageTypeForBuild = errors.New("packages should contain at least one main package")
+	// ErrTooManyMainPackagesForRun represents goc run command can only run one main package
+	ErrTooManyMainPackagesForRun = errors.New("packages should contain exactly one main package")
 	// ErrTooManyArgs represents goc CLI only support limited arguments
 	ErrTooManyArgs = errors.New("too many args")
 	// ErrInvalidWorkingDir represents the working directory is invalid
 	ErrInvalidWorkingDir = errors.New("the working directory is invalid")
 	// ErrEmptyTempWorkingDir represent the error that temporary working directory is empty
 	ErrEmptyTempWorkingDir = errors.New("temporary working directory is empty")
+	// ErrOverlayNeedsModule represents the overlay mode only support go modules projects
+	ErrOverlayNeedsModule = errors.New("overlay mode only support go modules projects")
 	// ErrNoPlaceToInstall represents the err that no place to install the generated binary
 	ErrNoPlaceToInstall = errors.New("don't know where to install")
 )
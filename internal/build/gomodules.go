@@ -1,6 +1,8 @@
 package build
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
@@ -27,12 +29,147 @@ func (b *Build) cpGoModulesProject() {
 		}
 	}
 }
+
+// replaceDirName is the directory in the temporary directory holding the locally replaced modules
+const replaceDirName = "goc-replace"
+
+// CpReplacedModules copies the modules replaced by local directories into the temporary directory
+// and points the replace directives to the copies, so that they are instrumented along with the main module
+func (b *Build) CpReplacedModules() error {
+	if !b.IsMod {
+		return nil
+	}
+	// use buildflags `-mod=vendor` and exist vendor folder, the replaced modules are vendored
+	if _, err := os.Stat(path.Join(b.ModRoot, "vendor")); err == nil && strings.Contains(b.BuildFlags, "-mod=vendor") {
+		logger.Warnf("replaced modules are not covered in vendor mode")
+		return nil
+	}
+	if b.OverlayFile != "" {
+		return b.overlayReplacedModules()
+	}
+	tempModfile := filepath.Join(b.TmpDir, "go.mod")
+	buf, err := ioutil.ReadFile(tempModfile)
+	if err != nil {
+		return err
+	}
+	goModFile, err := modfile.Parse(tempModfile, buf, nil)
+	if err != nil {
+		return err
+	}
+
+	replaces := append([]*modfile.Replace(nil), goModFile.Replace...)
+	if len(replaces) == 0 {
+		return nil
+	}
+	for _, replace := range replaces {
+		oldPath := replace.Old.Path
+		oldVersion := replace.Old.Version
+		newPath := replace.New.Path
+		// replace to a local filesystem does not have a version
+		if replace.New.Version != "" {
+			continue
+		}
+		if !filepath.IsAbs(newPath) {
+			newPath = filepath.Join(b.ModRoot, newPath)
+		}
+		dst, err := b.cpLocalModule(filepath.Clean(newPath), replaceDirName)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.TmpDir, dst)
+		if err != nil {
+			return err
+		}
+		// DropReplace & AddReplace will not return error
+		_ = goModFile.DropReplace(oldPath, oldVersion)
+		_ = goModFile.AddReplace(oldPath, oldVersion, "./"+filepath.ToSlash(rel), "")
+		// the replaced module is not part of the main module, list it by its module path
+		b.ExtraPackages = append(b.ExtraPackages, oldPath+"/...")
+		logger.Infof("replaced module %v copied to: %v", oldPath, dst)
+	}
+
+	goModFile.Cleanup()
+	newModFile, _ := goModFile.Format()
+	return ioutil.WriteFile(tempModfile, newModFile, os.ModePerm)
+}
+
+// overlayReplacedModules adds the packages of the locally replaced modules,
+// the project is built in place in overlay mode, so there is nothing to copy
+func (b *Build) overlayReplacedModules() error {
+	modFile := filepath.Join(b.ModRoot, "go.mod")
+	buf, err := ioutil.ReadFile(modFile)
+	if err != nil {
+		return err
+	}
+	goModFile, err := modfile.Parse(modFile, buf, nil)
+	if err != nil {
+		return err
+	}
+	for _, replace := range goModFile.Replace {
+		// replace to a local filesystem does not have a version
+		if replace.New.Version == "" {
+			b.ExtraPackages = append(b.ExtraPackages, replace.Old.Path+"/...")
+		}
+	}
+	return nil
+}
+
+// cpLocalModule copies the local module src and returns its directory in the temporary directory.
+// The modules inside the main module are copied along with it, others go into the parent directory.
+func (b *Build) cpLocalModule(src string, parent string) (string, error) {
+	if rel, err := filepath.Rel(b.ModRoot, src); err == nil && !strings.HasPrefix(rel, "..") {
+		dst := filepath.Join(b.TmpDir, rel)
+		if rel == "." {
+			// the main module has been handled already
+			return dst, nil
+		}
+		return dst, b.rewriteGoModFile(src, dst)
+	}
+
+	dst := filepath.Join(b.TmpDir, parent, localModuleDirName(src))
+	if err := copy.Copy(src, dst, copy.Options{Skip: skipCopy}); err != nil {
+		return "", fmt.Errorf("fail to copy the module from %v to %v: %w", src, dst, err)
+	}
+	if err := b.rewriteGoModFile(src, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// localModuleDirName returns a unique directory name for the local module in the temporary directory
+func localModuleDirName(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return fmt.Sprintf("%s-%x", filepath.Base(src), sum[:6])
+}
+
 func (b *Build) updateGoModFile() (updateFlag bool, newModFile []byte, err error) {
 	// use buildflags `-mod=vendor` and exist vendor folder, should not update go.mod
 	if _, err1 := os.Stat(path.Join(b.ModRoot, "vendor")); err1 == nil && strings.Contains(b.BuildFlags, "-mod=vendor") {
 		return
 	}
-	tempModfile := filepath.Join(b.TmpDir, "go.mod")
+	return updateModFile(b.ModRoot, b.TmpDir)
+}
+
+// rewriteGoModFile rewrites the go.mod copied from oriModDir to tmpModDir if needed
+func (b *Build) rewriteGoModFile(oriModDir, tmpModDir string) error {
+	updated, newGoModContent, err := updateModFile(oriModDir, tmpModDir)
+	if err != nil {
+		return fmt.Errorf("fail to generate new go.mod for %v: %w", oriModDir, err)
+	}
+	if updated {
+		logger.Infof("go.mod in %v needs rewrite", tmpModDir)
+		err := ioutil.WriteFile(filepath.Join(tmpModDir, "go.mod"), newGoModContent, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("fail to update go.mod: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateModFile rewrites the relative replace directives of the go.mod in tmpModDir
+// to absolute paths based on the original module directory
+func updateModFile(oriModDir, tmpModDir string) (updateFlag bool, newModFile []byte, err error) {
+	tempModfile := filepath.Join(tmpModDir, "go.mod")
 	buf, err := ioutil.ReadFile(tempModfile)
 	if err != nil {
 		return
@@ -53,7 +190,7 @@ func (b *Build) updateGoModFile() (updateFlag bool, newModFile []byte, err error
 		// absolute path no need to rewrite
 		if newVersion == "" && !filepath.IsAbs(newPath) {
 			var absPath string
-			fullPath := filepath.Join(b.ModRoot, newPath)
+			fullPath := filepath.Join(oriModDir, newPath)
 			absPath, _ = filepath.Abs(fullPath)
 			// DropReplace & AddReplace will not return error
 			// so no need to check the error
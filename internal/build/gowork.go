@@ -0,0 +1,124 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spelens-gud/logger"
+	"golang.org/x/mod/modfile"
+)
+
+// workspaceDirName is the directory in the temporary directory holding the workspace modules
+// which are not inside the main module
+const workspaceDirName = "goc-workspace"
+
+// findGoWork returns the go.work file used by the working directory, empty if not in workspace mode
+func (b *Build) findGoWork() (string, error) {
+	cmd := exec.Command("go", "env", "GOWORK")
+	cmd.Dir = b.WorkingDir
+	var errbuf bytes.Buffer
+	cmd.Stderr = &errbuf
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("fail to execute `go env GOWORK`, err: %w, stderr: %v", err, errbuf.String())
+	}
+	gowork := strings.TrimSpace(string(out))
+	if gowork == "off" {
+		return "", nil
+	}
+	return gowork, nil
+}
+
+// cpGoWorkspace copies all the modules used by go.work into the temporary directory,
+// and writes a new go.work pointing to the copied modules.
+// The main module is expected to be copied to b.TmpDir already.
+func (b *Build) cpGoWorkspace() error {
+	buf, err := os.ReadFile(b.GoWorkFile)
+	if err != nil {
+		return err
+	}
+	workFile, err := modfile.ParseWork(b.GoWorkFile, buf, nil)
+	if err != nil {
+		return err
+	}
+	workDir := filepath.Dir(b.GoWorkFile)
+
+	var uses []*modfile.Use
+	for _, use := range workFile.Use {
+		src := workspaceModuleDir(workDir, use)
+		dst, err := b.cpLocalModule(src, workspaceDirName)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.TmpDir, dst)
+		if err != nil {
+			return err
+		}
+		if rel != "." {
+			b.ExtraPackages = append(b.ExtraPackages, "./"+filepath.ToSlash(rel)+"/...")
+		}
+		uses = append(uses, &modfile.Use{Path: "./" + filepath.ToSlash(rel), ModulePath: use.ModulePath})
+	}
+	workFile.SetUse(uses)
+
+	// relative replace directives are relative to the original go.work
+	replaces := append([]*modfile.Replace(nil), workFile.Replace...)
+	for _, replace := range replaces {
+		oldPath := replace.Old.Path
+		oldVersion := replace.Old.Version
+		newPath := replace.New.Path
+		if replace.New.Version == "" && !filepath.IsAbs(newPath) {
+			absPath, _ := filepath.Abs(filepath.Join(workDir, newPath))
+			// DropReplace & AddReplace will not return error
+			_ = workFile.DropReplace(oldPath, oldVersion)
+			_ = workFile.AddReplace(oldPath, oldVersion, absPath, "")
+		}
+	}
+	workFile.Cleanup()
+
+	b.NewGOWORK = filepath.Join(b.TmpDir, "go.work")
+	if err := os.WriteFile(b.NewGOWORK, modfile.Format(workFile.Syntax), os.ModePerm); err != nil {
+		return fmt.Errorf("fail to write go.work: %w", err)
+	}
+	// keep the checksums of the workspace dependencies
+	if sum, err := os.ReadFile(b.GoWorkFile + ".sum"); err == nil {
+		if err := os.WriteFile(b.NewGOWORK+".sum", sum, os.ModePerm); err != nil {
+			return fmt.Errorf("fail to write go.work.sum: %w", err)
+		}
+	}
+	logger.Infof("go.work generated in: %v, extra modules: %v", b.NewGOWORK, b.ExtraPackages)
+	return nil
+}
+
+// overlayGoWorkspace adds the packages of the other modules used by go.work,
+// the workspace is built in place in overlay mode, so there is nothing to copy
+func (b *Build) overlayGoWorkspace() error {
+	buf, err := os.ReadFile(b.GoWorkFile)
+	if err != nil {
+		return err
+	}
+	workFile, err := modfile.ParseWork(b.GoWorkFile, buf, nil)
+	if err != nil {
+		return err
+	}
+	workDir := filepath.Dir(b.GoWorkFile)
+	for _, use := range workFile.Use {
+		if dir := workspaceModuleDir(workDir, use); dir != b.ModRoot {
+			b.ExtraPackages = append(b.ExtraPackages, filepath.ToSlash(dir)+"/...")
+		}
+	}
+	return nil
+}
+
+// workspaceModuleDir returns the absolute directory of the module used by go.work
+func workspaceModuleDir(workDir string, use *modfile.Use) string {
+	dir := use.Path
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(workDir, dir)
+	}
+	return filepath.Clean(dir)
+}
@@ -0,0 +1,100 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spelens-gud/logger"
+)
+
+// NewInstall creates a Build struct which can install from goc temporary directory
+func NewInstall(buildflags string, args []string, workingDir string, opts ...Option) (*Build, error) {
+	if len(args) > 1 {
+		logger.Error(ErrTooManyArgs.Error())
+		return nil, ErrTooManyArgs
+	}
+	if err := checkParameters(args, workingDir); err != nil {
+		return nil, err
+	}
+	b := &Build{
+		BuildFlags: buildflags,
+		Packages:   strings.Join(args, " "),
+		WorkingDir: workingDir,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if false == b.validatePackageForInstall() {
+		logger.Error(ErrWrongPackageTypeForInstall.Error())
+		return nil, ErrWrongPackageTypeForInstall
+	}
+	// go install . only installs the main package in the working directory, ./... installs all of them
+	if b.Packages != "./..." {
+		if err := b.validatePackageForBuild(); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.MvProjectsToTmp(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Install use the 'go install' tool to install packages
+func (b *Build) Install() error {
+	logger.Info("Go installing in temp...")
+	cmd := exec.Command("/bin/bash", "-c", "go install "+b.goBuildFlags()+" "+b.Packages)
+	cmd.Dir = b.TmpWorkingDir
+
+	whereToInstall, err := b.findWhereToInstall()
+	if err != nil {
+		return err
+	}
+	// Change the temp GOBIN, to force binary install to original place
+	cmd.Env = append(b.goEnv(), fmt.Sprintf("GOBIN=%v", whereToInstall))
+
+	logger.Infof("go install cmd is: %v", cmd.Args)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fail to execute: %v, err: %w, output: %s", cmd.Args, err, string(out))
+	}
+	logger.Infof("Go install successful. Binary installed in: %v", whereToInstall)
+	return nil
+}
+
+// validatePackageForInstall only allow . and ./... as package name
+func (b *Build) validatePackageForInstall() bool {
+	if b.Packages == "." || b.Packages == "" || b.Packages == "./..." {
+		return true
+	}
+	return false
+}
+
+// findWhereToInstall returns the install location, same as 'go install':
+// 1. GOBIN if set
+// 2. the bin directory of the GOPATH the legacy project lives in
+// 3. the bin directory of the first GOPATH entry, default to $HOME/go/bin
+func (b *Build) findWhereToInstall() (string, error) {
+	if gobin := os.Getenv("GOBIN"); gobin != "" {
+		return gobin, nil
+	}
+
+	if false == b.IsMod {
+		if b.Root == "" {
+			return "", ErrNoPlaceToInstall
+		}
+		return filepath.Join(b.Root, "bin"), nil
+	}
+	// use the original GOPATH, the temporary one is only for legacy projects
+	if gopath := os.Getenv("GOPATH"); gopath != "" {
+		return filepath.Join(filepath.SplitList(gopath)[0], "bin"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNoPlaceToInstall, err)
+	}
+	return filepath.Join(home, "go", "bin"), nil
+}
@@ -0,0 +1,64 @@
+package build
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spelens-gud/golangci-scope/internal/cover"
+	"github.com/spelens-gud/logger"
+)
+
+// VCSRevision returns the git revision of the working directory and whether the working tree is modified,
+// the revision is empty if the project is not in a git repository
+func (b *Build) VCSRevision() (string, bool) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = b.WorkingDir
+	out, err := cmd.Output()
+	if err != nil {
+		logger.Infof("no git revision found in %v: %v", b.WorkingDir, err)
+		return "", false
+	}
+	revision := strings.TrimSpace(string(out))
+
+	cmd = exec.Command("git", "status", "--porcelain")
+	cmd.Dir = b.WorkingDir
+	out, err = cmd.Output()
+	return revision, err == nil && len(strings.TrimSpace(string(out))) != 0
+}
+
+// BinaryPath returns the path of the binary generated for the main package, empty if unknown
+func (b *Build) BinaryPath(importPath string) string {
+	if !b.OneMainPackage {
+		// go install writes the binaries to their install targets
+		if pkg, ok := b.Pkgs[importPath]; ok {
+			return pkg.Target
+		}
+		return ""
+	}
+	if len(b.MainPackages) > 1 {
+		return filepath.Join(b.Target, path.Base(importPath))
+	}
+	return b.Target
+}
+
+// WriteManifests writes the manifest of each generated binary next to it,
+// the main packages without a binary, such as the ones not installed, are skipped
+func (b *Build) WriteManifests(manifests map[string]*cover.Manifest) error {
+	for importPath, manifest := range manifests {
+		binary := b.BinaryPath(importPath)
+		if binary == "" {
+			continue
+		}
+		if _, err := os.Stat(binary); err != nil {
+			continue
+		}
+		if err := manifest.WriteFile(binary + cover.ManifestSuffix); err != nil {
+			return err
+		}
+		logger.Infof("manifest of %v generated: %v", importPath, binary+cover.ManifestSuffix)
+	}
+	return nil
+}
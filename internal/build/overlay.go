@@ -0,0 +1,69 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spelens-gud/logger"
+)
+
+// prepareOverlay prepares a scratch directory for the instrumented files,
+// the project is built in place with the -overlay flag instead of being copied
+func (b *Build) prepareOverlay() error {
+	b.TmpDir = filepath.Join(os.TempDir(), tmpFolderName(b.WorkingDir))
+
+	// Delete previous scratch folder and its content
+	os.RemoveAll(b.TmpDir)
+	err := os.MkdirAll(b.TmpDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("Fail to create the overlay directory. The err is: %v", err)
+	}
+	// the package for storing cover variables only exists in the overlay
+	b.GlobalCoverVarImportPath = filepath.Join("src", tmpPackageName(b.WorkingDir))
+	b.OverlayFile = filepath.Join(b.TmpDir, "overlay.json")
+	logger.Infof("Overlay generated in: %v", b.OverlayFile)
+
+	// traverse pkg list to get project meta info
+	b.IsMod, b.Root, err = b.traversePkgsList()
+	if errors.Is(err, ErrShouldNotReached) {
+		return fmt.Errorf("prepareOverlay with a empty project: %w", err)
+	}
+	if !b.IsMod {
+		logger.Error(ErrOverlayNeedsModule.Error())
+		return ErrOverlayNeedsModule
+	}
+	b.TmpWorkingDir = b.WorkingDir
+
+	b.GoWorkFile, err = b.findGoWork()
+	if err != nil {
+		return err
+	}
+	if b.GoWorkFile != "" {
+		logger.Infof("go.work detected: %v", b.GoWorkFile)
+		return b.overlayGoWorkspace()
+	}
+	return nil
+}
+
+// SourceDir returns the project directory to instrument,
+// it's the temporary directory unless the project is built with an overlay
+func (b *Build) SourceDir() string {
+	if b.OverlayFile != "" {
+		return b.ModRoot
+	}
+	return b.TmpDir
+}
+
+// goBuildFlags returns the build flags for the go commands building the instrumented project
+func (b *Build) goBuildFlags() string {
+	flags := b.BuildFlags
+	if b.CoverFlags != "" {
+		flags += " " + b.CoverFlags
+	}
+	if b.OverlayFile != "" {
+		flags += " -overlay=" + b.OverlayFile
+	}
+	return flags
+}
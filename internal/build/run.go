@@ -10,13 +10,10 @@ import (
 
 // Run excutes the main package in addition with the internal goc features
 func (b *Build) Run() error {
-	cmd := exec.Command("/bin/bash", "-c", "go run "+b.BuildFlags+" "+b.GoRunExecFlag+" "+b.Packages+" "+b.GoRunArguments)
+	cmd := exec.Command("/bin/bash", "-c", "go run "+b.goBuildFlags()+" "+b.GoRunExecFlag+" "+b.Packages+" "+b.GoRunArguments)
 	cmd.Dir = b.TmpWorkingDir
 
-	if b.NewGOPATH != "" {
-		// Change to temp GOPATH for go install command
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GOPATH=%v", b.NewGOPATH))
-	}
+	cmd.Env = b.goEnv()
 
 	logger.Infof("go build cmd is: %v", cmd.Args)
 	cmd.Stdout = os.Stdout
@@ -28,7 +28,11 @@ func (b *Build) MvProjectsToTmp() error {
 		return err
 	}
 
-	err = b.mvProjectsToTmp()
+	if b.UseOverlay {
+		err = b.prepareOverlay()
+	} else {
+		err = b.mvProjectsToTmp()
+	}
 	if err != nil {
 		logger.Errorf("Fail to move the project to temporary directory")
 		return err
@@ -96,6 +100,16 @@ func (b *Build) mvProjectsToTmp() error {
 				return fmt.Errorf("fail to update go.mod: %v", err)
 			}
 		}
+		b.GoWorkFile, err = b.findGoWork()
+		if err != nil {
+			return err
+		}
+		if b.GoWorkFile != "" {
+			logger.Infof("go.work detected: %v", b.GoWorkFile)
+			if err := b.cpGoWorkspace(); err != nil {
+				return fmt.Errorf("fail to copy the go workspace: %w", err)
+			}
+		}
 	} else if b.IsMod == false && b.Root == "" {
 		b.TmpWorkingDir = b.TmpDir
 		b.cpNonStandardLegacy()
@@ -118,43 +132,74 @@ func tmpPackageName(path string) string {
 	return "gocbuild" + h
 }
 func (b *Build) traversePkgsList() (isMod bool, root string, err error) {
+	// in a go workspace the packages may come from several modules,
+	// the innermost module holding the working directory is the one to build
+	var fallback *cover.Package
 	for _, v := range b.Pkgs {
-		// get root
-		root = v.Root
 		if v.Module == nil {
-			return
+			// get root
+			return false, v.Root, nil
 		}
-		isMod = true
-		b.ModRoot = v.Module.Dir
-		b.ModRootPath = v.Module.Path
-		return
-	}
-	logger.Error(ErrShouldNotReached.Error())
-	err = ErrShouldNotReached
-	return
+		if isSubDir(v.Module.Dir, b.WorkingDir) && len(v.Module.Dir) > len(b.ModRoot) {
+			b.ModRoot = v.Module.Dir
+			b.ModRootPath = v.Module.Path
+			root = v.Root
+		}
+		// the map order is random, the module of the smallest directory is the fallback
+		if fallback == nil || v.Module.Dir < fallback.Module.Dir {
+			fallback = v
+		}
+	}
+	if fallback == nil {
+		logger.Error(ErrShouldNotReached.Error())
+		return false, "", ErrShouldNotReached
+	}
+	if b.ModRoot == "" {
+		b.ModRoot = fallback.Module.Dir
+		b.ModRootPath = fallback.Module.Path
+		root = fallback.Root
+	}
+	return true, root, nil
+}
+
+// isSubDir reports whether path is dir or inside it
+func isSubDir(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
 }
 
 func (b *Build) getTmpwd() (string, error) {
-	for _, pkg := range b.Pkgs {
-		var index int
-		var parentPath string
-		if b.IsMod == false {
-			index = strings.Index(b.WorkingDir, pkg.Root)
+	if len(b.Pkgs) == 0 {
+		return "", ErrShouldNotReached
+	}
+	// the module chosen by traversePkgsList is the one copied to the temporary directory
+	parentPath := b.ModRoot
+	if !b.IsMod {
+		for _, pkg := range b.Pkgs {
 			parentPath = pkg.Root
-		} else {
-			index = strings.Index(b.WorkingDir, pkg.Module.Dir)
-			parentPath = pkg.Module.Dir
-		}
-
-		if index == -1 {
-			return "", ErrGocShouldExecInProject
+			break
 		}
-		// b.TmpWorkingDir = filepath.Join(b.TmpDir, path[len(parentPath):])
-		return filepath.Join(b.TmpDir, b.WorkingDir[len(parentPath):]), nil
 	}
+	if !isSubDir(parentPath, b.WorkingDir) {
+		return "", ErrGocShouldExecInProject
+	}
+	// b.TmpWorkingDir = filepath.Join(b.TmpDir, path[len(parentPath):])
+	return filepath.Join(b.TmpDir, b.WorkingDir[len(parentPath):]), nil
+}
 
-	return "", ErrShouldNotReached
+// goEnv returns the environment for the go commands executed in the temporary directory
+func (b *Build) goEnv() []string {
+	env := os.Environ()
+	if b.NewGOPATH != "" {
+		// Change to temp GOPATH for go command
+		env = append(env, fmt.Sprintf("GOPATH=%v", b.NewGOPATH))
+	}
+	if b.NewGOWORK != "" {
+		// Change to temp go.work in case GOWORK is set explicitly
+		env = append(env, fmt.Sprintf("GOWORK=%v", b.NewGOWORK))
+	}
+	return env
 }
+
 func (b *Build) Clean() error {
 	if !viper.GetBool("debug") {
 		return os.RemoveAll(b.TmpDir)
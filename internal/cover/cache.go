@@ -0,0 +1,119 @@
+package cover
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	tool "github.com/spelens-gud/golangci-scope/internal/cover/internal"
+	"github.com/spelens-gud/logger"
+)
+
+// cacheVersion is part of every cache key, bump it when the annotated output changes
+const cacheVersion = "v2"
+
+// Cache keeps the annotated files and their cover variables declarations across builds,
+// keyed by the file content, the cover mode, the cover variable and the global cover var import path,
+// so only the changed files are annotated again.
+type Cache struct {
+	dir string
+}
+
+// NewCache creates a cache storing the entries in dir
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("create cover cache dir %s: %w", dir, err)
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Annotate annotates the source file name into dest like tool.Annotate, reusing the cached result if any.
+// A nil cache always annotates the file.
+func (c *Cache) Annotate(name string, dest string, mode string, varVar string, globalCoverVarImportPath string) (string, error) {
+	if c == nil {
+		return tool.Annotate(name, dest, mode, varVar, globalCoverVarImportPath)
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("cover: %s: %w", name, err)
+	}
+	key := cacheKey(name, content, mode, varVar, globalCoverVarImportPath)
+	if annotated, decl, ok := c.get(key); ok {
+		if err := os.WriteFile(dest, annotated, 0644); err != nil {
+			return "", fmt.Errorf("cover: %s: %w", name, err)
+		}
+		return decl, nil
+	}
+
+	decl, err := tool.Annotate(name, dest, mode, varVar, globalCoverVarImportPath)
+	if err != nil {
+		return "", err
+	}
+	annotated, err := os.ReadFile(dest)
+	if err != nil {
+		return "", fmt.Errorf("cover: %s: %w", name, err)
+	}
+	// a broken cache only costs the next build some time
+	if err := c.put(key, annotated, decl); err != nil {
+		logger.Warnf("fail to cache the annotated file %s: %v", name, err)
+	}
+	return decl, nil
+}
+
+// cacheKey hashes everything the annotated output depends on,
+// the file name is included as it is written into the //line directive
+func cacheKey(name string, content []byte, mode string, varVar string, globalCoverVarImportPath string) string {
+	h := sha256.New()
+	for _, s := range []string{cacheVersion, name, mode, varVar, globalCoverVarImportPath} {
+		fmt.Fprintf(h, "%s\x00", s)
+	}
+	h.Write(content)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+func (c *Cache) get(key string) ([]byte, string, bool) {
+	annotated, err := os.ReadFile(c.path(key) + ".go")
+	if err != nil {
+		return nil, "", false
+	}
+	decl, err := os.ReadFile(c.path(key) + ".decl")
+	if err != nil {
+		return nil, "", false
+	}
+	return annotated, string(decl), true
+}
+
+// put writes the declarations last, an entry without them is treated as a miss
+func (c *Cache) put(key string, annotated []byte, decl string) error {
+	if err := os.MkdirAll(filepath.Dir(c.path(key)), os.ModePerm); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path(key)+".go", annotated); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(key)+".decl", []byte(decl))
+}
+
+// writeFileAtomic writes to a temporary file and renames it,
+// so concurrent builds never read a partial entry
+func writeFileAtomic(name string, content []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -3,6 +3,7 @@ package cover
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -25,17 +26,24 @@ const (
 	CoverServicesListAPI = "/v1/cover/list"
 	//CoverRegisterServiceAPI register a service into service center
 	CoverRegisterServiceAPI = "/v1/cover/register"
+	//CoverHeartbeatAPI tells a registered service whether it is still known to the service center
+	CoverHeartbeatAPI = "/v1/cover/heartbeat"
 	//CoverServicesRemoveAPI remove one services from the service center
 	CoverServicesRemoveAPI = "/v1/cover/remove"
+	//CoverManifestAPI is provided by the covered service to get its build manifest,
+	//and by the service center to get the source hashes of the services
+	CoverManifestAPI = "/v1/cover/manifest"
 )
 
 // Action provides methods to contact with the covered service under test
 type Action interface {
 	Profile(param ProfileParam) ([]byte, error)
 	Clear(param ProfileParam) ([]byte, error)
+	Manifest(param ProfileParam) ([]byte, error)
 	Remove(param ProfileParam) ([]byte, error)
 	InitSystem() ([]byte, error)
 	ListServices() ([]byte, error)
+	ListServicesHealth() ([]byte, error)
 	RegisterService(svr ServiceUnderTest) ([]byte, error)
 }
 type client struct {
@@ -68,14 +76,30 @@ func (c *client) RegisterService(srv ServiceUnderTest) ([]byte, error) {
 
 func (c *client) ListServices() ([]byte, error) {
 	u := fmt.Sprintf("%s%s", c.Host, CoverServicesListAPI)
-	_, services, err := c.do("GET", u, "", nil)
+	res, services, err := c.do("GET", u, "", nil)
 	if err != nil && isNetworkError(err) {
-		_, services, err = c.do("GET", u, "", nil)
+		res, services, err = c.do("GET", u, "", nil)
 	}
 
+	if err == nil && res.StatusCode != 200 {
+		err = errors.New(string(services))
+	}
 	return services, err
 }
 
+func (c *client) ListServicesHealth() ([]byte, error) {
+	u := fmt.Sprintf("%s%s?health=true", c.Host, CoverServicesListAPI)
+	res, health, err := c.do("GET", u, "", nil)
+	if err != nil && isNetworkError(err) {
+		res, health, err = c.do("GET", u, "", nil)
+	}
+
+	if err == nil && res.StatusCode != 200 {
+		err = errors.New(string(health))
+	}
+	return health, err
+}
+
 func (c *client) Profile(param ProfileParam) ([]byte, error) {
 	u := fmt.Sprintf("%s%s", c.Host, CoverProfileAPI)
 	if len(param.Service) != 0 && len(param.Address) != 0 {
@@ -92,7 +116,7 @@ func (c *client) Profile(param ProfileParam) ([]byte, error) {
 	}
 
 	if err == nil && res.StatusCode != 200 {
-		err = fmt.Errorf(string(profile))
+		err = errors.New(string(profile))
 	}
 	return profile, err
 }
@@ -106,9 +130,33 @@ func (c *client) Clear(param ProfileParam) ([]byte, error) {
 	// the json.Marshal function can return two types of errors: UnsupportedTypeError or UnsupportedValueError
 	// so no need to check here
 	body, _ := json.Marshal(param)
-	_, resp, err := c.do("POST", u, "application/json", bytes.NewReader(body))
+	res, resp, err := c.do("POST", u, "application/json", bytes.NewReader(body))
+	if err != nil && isNetworkError(err) {
+		res, resp, err = c.do("POST", u, "application/json", bytes.NewReader(body))
+	}
+
+	if err == nil && res.StatusCode != 200 {
+		err = errors.New(string(resp))
+	}
+	return resp, err
+}
+
+func (c *client) Manifest(param ProfileParam) ([]byte, error) {
+	u := fmt.Sprintf("%s%s", c.Host, CoverManifestAPI)
+	if len(param.Service) != 0 && len(param.Address) != 0 {
+		return nil, fmt.Errorf("use 'service' flag and 'address' flag at the same time may cause ambiguity, please use them separately")
+	}
+
+	// the json.Marshal function can return two types of errors: UnsupportedTypeError or UnsupportedValueError
+	// so no need to check here
+	body, _ := json.Marshal(param)
+	res, resp, err := c.do("POST", u, "application/json", bytes.NewReader(body))
 	if err != nil && isNetworkError(err) {
-		_, resp, err = c.do("POST", u, "application/json", bytes.NewReader(body))
+		res, resp, err = c.do("POST", u, "application/json", bytes.NewReader(body))
+	}
+
+	if err == nil && res.StatusCode != 200 {
+		err = errors.New(string(resp))
 	}
 	return resp, err
 }
@@ -125,16 +173,23 @@ func (c *client) Remove(param ProfileParam) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	_, resp, err := c.do("POST", u, "application/json", bytes.NewReader(body))
+	res, resp, err := c.do("POST", u, "application/json", bytes.NewReader(body))
 	if err != nil && isNetworkError(err) {
-		_, resp, err = c.do("POST", u, "application/json", bytes.NewReader(body))
+		res, resp, err = c.do("POST", u, "application/json", bytes.NewReader(body))
+	}
+
+	if err == nil && res.StatusCode != 200 {
+		err = errors.New(string(resp))
 	}
 	return resp, err
 }
 
 func (c *client) InitSystem() ([]byte, error) {
 	u := fmt.Sprintf("%s%s", c.Host, CoverInitSystemAPI)
-	_, body, err := c.do("POST", u, "", nil)
+	res, body, err := c.do("POST", u, "", nil)
+	if err == nil && res.StatusCode != 200 {
+		err = errors.New(string(body))
+	}
 	return body, err
 }
 
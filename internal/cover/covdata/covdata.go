@@ -0,0 +1,351 @@
+// Package covdata converts the coverage data written by runtime/coverage into a text profile.
+//
+// The programs built with the native backend carry a copy of this file to serve their profiles,
+// so it only imports the standard library. The data formats follow internal/coverage of the Go toolchain,
+// the data of any other version is rejected instead of being guessed at, convert it with go tool covdata textfmt.
+package covdata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	metaMagic      = "\x00cvm"
+	counterMagic   = "\x00cwm"
+	metaVersion    = 1
+	counterVersion = 1
+
+	metaSymbolHeaderSize  = 44
+	counterFileFooterSize = 16
+
+	ctrRaw     = 1 // counters are raw uint32 values
+	ctrULeb128 = 2 // counters are ULEB128 encoded
+)
+
+// errShort is returned for the data cut off in the middle
+var errShort = errors.New("covdata: unexpected end of data")
+
+// errMismatch is returned for the counters written by another program than the meta-data
+var errMismatch = errors.New("covdata: the counter data does not match the meta-data")
+
+// the counter modes in the meta-data header
+var counterModes = map[uint8]string{1: "set", 2: "count", 3: "atomic"}
+
+// Meta is the coverage meta-data of a program, it never changes while the program runs
+type Meta struct {
+	Mode string
+	hash [16]byte
+	pkgs []metaPackage
+}
+
+type metaPackage struct {
+	path  string
+	funcs []metaFunc
+}
+
+type metaFunc struct {
+	file  string
+	units []Block
+}
+
+// Block is a basic block of the profile
+type Block struct {
+	File      string
+	StartLine uint32
+	StartCol  uint32
+	EndLine   uint32
+	EndCol    uint32
+	NumStmt   uint32
+	Count     uint32
+}
+
+// reader decodes little endian values and ULEB128 numbers,
+// the first error sticks and the following reads return zero values
+type reader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.off+n > len(r.b) {
+		r.err = errShort
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *reader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) u32(order binary.ByteOrder) uint32 {
+	if b := r.bytes(4); b != nil {
+		return order.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) u64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) uleb() uint64 {
+	var value uint64
+	var shift uint
+	for {
+		b := r.u8()
+		if r.err != nil {
+			return 0
+		}
+		value |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return value
+		}
+		shift += 7
+	}
+}
+
+func (r *reader) strtab() []string {
+	n := r.uleb()
+	var strs []string
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		strs = append(strs, string(r.bytes(int(r.uleb()))))
+	}
+	return strs
+}
+
+// ParseMeta decodes the meta-data written by runtime/coverage.WriteMeta
+func ParseMeta(data []byte) (*Meta, error) {
+	r := &reader{b: data}
+	if string(r.bytes(4)) != metaMagic {
+		return nil, errors.New("covdata: invalid meta-data magic")
+	}
+	if v := r.u32(binary.LittleEndian); v != metaVersion {
+		return nil, fmt.Errorf("covdata: unsupported meta-data version %d, want %d", v, metaVersion)
+	}
+	length := r.u64()
+	entries := r.u64()
+	meta := &Meta{}
+	copy(meta.hash[:], r.bytes(16))
+	r.bytes(8) // offset and length of the string table
+	mode := r.u8()
+	r.bytes(1 + 6) // counter granularity and padding
+	if r.err != nil {
+		return nil, r.err
+	}
+	if length != uint64(len(data)) {
+		return nil, fmt.Errorf("covdata: meta-data length %d, want %d", len(data), length)
+	}
+	if meta.Mode = counterModes[mode]; meta.Mode == "" {
+		return nil, fmt.Errorf("covdata: unknown counter mode %d", mode)
+	}
+	offsets := make([]uint64, entries)
+	for i := range offsets {
+		offsets[i] = r.u64()
+	}
+	lengths := make([]uint64, entries)
+	for i := range lengths {
+		lengths[i] = r.u64()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	for i := range offsets {
+		if offsets[i]+lengths[i] > uint64(len(data)) {
+			return nil, errShort
+		}
+		pkg, err := parsePackage(data[offsets[i] : offsets[i]+lengths[i]])
+		if err != nil {
+			return nil, err
+		}
+		meta.pkgs = append(meta.pkgs, pkg)
+	}
+	return meta, nil
+}
+
+func parsePackage(blob []byte) (metaPackage, error) {
+	r := &reader{b: blob}
+	le := binary.LittleEndian
+	r.u32(le) // length
+	r.u32(le) // package name
+	pkgPath := r.u32(le)
+	r.u32(le)       // module path
+	r.bytes(16 + 4) // hash and padding
+	r.u32(le)       // number of files
+	numFuncs := r.u32(le)
+	funcOffsets := make([]uint32, 0, numFuncs)
+	for i := uint32(0); i < numFuncs && r.err == nil; i++ {
+		funcOffsets = append(funcOffsets, r.u32(le))
+	}
+	strs := r.strtab()
+	if r.err != nil {
+		return metaPackage{}, r.err
+	}
+	str := func(idx uint64) string {
+		if idx >= uint64(len(strs)) {
+			r.err = fmt.Errorf("covdata: invalid string index %d", idx)
+			return ""
+		}
+		return strs[idx]
+	}
+
+	pkg := metaPackage{path: str(uint64(pkgPath))}
+	for _, off := range funcOffsets {
+		if int(off) < metaSymbolHeaderSize || int(off) > len(blob) {
+			return metaPackage{}, fmt.Errorf("covdata: invalid function offset %d", off)
+		}
+		r.off = int(off)
+		numUnits := r.uleb()
+		r.uleb() // function name
+		fn := metaFunc{file: str(r.uleb())}
+		for k := uint64(0); k < numUnits && r.err == nil; k++ {
+			fn.units = append(fn.units, Block{
+				File:      fn.file,
+				StartLine: uint32(r.uleb()),
+				StartCol:  uint32(r.uleb()),
+				EndLine:   uint32(r.uleb()),
+				EndCol:    uint32(r.uleb()),
+				NumStmt:   uint32(r.uleb()),
+			})
+		}
+		r.uleb() // function literal flag
+		pkg.funcs = append(pkg.funcs, fn)
+	}
+	return pkg, r.err
+}
+
+// parseCounters decodes the counters written by runtime/coverage.WriteCounters,
+// keyed by the package and function index in the meta-data with the hash
+func parseCounters(data []byte, hash [16]byte) (map[[2]uint32][]uint32, error) {
+	r := &reader{b: data}
+	if string(r.bytes(4)) != counterMagic {
+		return nil, errors.New("covdata: invalid counter data magic")
+	}
+	if v := r.u32(binary.LittleEndian); v != counterVersion {
+		return nil, fmt.Errorf("covdata: unsupported counter data version %d, want %d", v, counterVersion)
+	}
+	if h := r.bytes(16); r.err == nil && string(h) != string(hash[:]) {
+		return nil, errMismatch
+	}
+	flavor := r.u8()
+	var order binary.ByteOrder = binary.LittleEndian
+	if r.u8() != 0 {
+		order = binary.BigEndian
+	}
+	r.bytes(6) // padding
+	if r.err != nil {
+		return nil, r.err
+	}
+	var next func() uint32
+	switch flavor {
+	case ctrULeb128:
+		next = func() uint32 { return uint32(r.uleb()) }
+	case ctrRaw:
+		next = func() uint32 { return r.u32(order) }
+	default:
+		return nil, fmt.Errorf("covdata: unknown counter flavor %d", flavor)
+	}
+
+	if len(data) < r.off+counterFileFooterSize {
+		return nil, errShort
+	}
+	footer := &reader{b: data, off: len(data) - counterFileFooterSize}
+	if string(footer.bytes(4)) != counterMagic {
+		return nil, errors.New("covdata: invalid counter data footer")
+	}
+	footer.bytes(4) // padding
+	segments := footer.u32(binary.LittleEndian)
+
+	counters := make(map[[2]uint32][]uint32)
+	for seg := uint32(0); seg < segments && r.err == nil; seg++ {
+		if seg > 0 {
+			r.bytes(counterFileFooterSize) // the footer between the segments
+		}
+		entries := r.u64()
+		strTabLen := r.u32(binary.LittleEndian)
+		argsLen := r.u32(binary.LittleEndian)
+		r.bytes(int(strTabLen) + int(argsLen))
+		if pad := r.off % 4; pad != 0 {
+			r.bytes(4 - pad)
+		}
+		for i := uint64(0); i < entries && r.err == nil; i++ {
+			n := next()
+			key := [2]uint32{next(), next()}
+			values := counters[key]
+			for k := uint32(0); k < n && r.err == nil; k++ {
+				v := next()
+				if int(k) < len(values) {
+					values[k] += v
+				} else {
+					values = append(values, v)
+				}
+			}
+			counters[key] = values
+		}
+	}
+	return counters, r.err
+}
+
+// Blocks returns the blocks of the meta-data with the counters written by runtime/coverage.WriteCounters,
+// sorted by the file and the position. The blocks of the files skip reports true for are left out.
+func (m *Meta) Blocks(counterData []byte, skip func(file string) bool) ([]Block, error) {
+	counters, err := parseCounters(counterData, m.hash)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []Block
+	for pkgIdx, pkg := range m.pkgs {
+		for fnIdx, fn := range pkg.funcs {
+			if skip != nil && skip(fn.file) {
+				continue
+			}
+			values := counters[[2]uint32{uint32(pkgIdx), uint32(fnIdx)}]
+			for i, unit := range fn.units {
+				if i < len(values) {
+					unit.Count = values[i]
+				}
+				if m.Mode == "set" && unit.Count > 0 {
+					unit.Count = 1
+				}
+				blocks = append(blocks, unit)
+			}
+		}
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		if blocks[i].File != blocks[j].File {
+			return blocks[i].File < blocks[j].File
+		}
+		if blocks[i].StartLine != blocks[j].StartLine {
+			return blocks[i].StartLine < blocks[j].StartLine
+		}
+		return blocks[i].StartCol < blocks[j].StartCol
+	})
+	return blocks, nil
+}
+
+// WriteProfile writes the blocks as a text profile
+func (m *Meta) WriteProfile(w io.Writer, blocks []Block) error {
+	if _, err := fmt.Fprintf(w, "mode: %s\n", m.Mode); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if _, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt, b.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package covdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The testdata is written by a program built with go build -cover -covermode=<mode>,
+// <mode>.txt is the conversion of go tool covdata textfmt of the same data.
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestWriteProfile(t *testing.T) {
+	for _, mode := range []string{"set", "count"} {
+		t.Run(mode, func(t *testing.T) {
+			meta, err := ParseMeta(readTestdata(t, mode+".covmeta"))
+			if err != nil {
+				t.Fatalf("ParseMeta: %v", err)
+			}
+			if meta.Mode != mode {
+				t.Errorf("mode %q, want %q", meta.Mode, mode)
+			}
+			blocks, err := meta.Blocks(readTestdata(t, mode+".covcounters"), nil)
+			if err != nil {
+				t.Fatalf("Blocks: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := meta.WriteProfile(&buf, blocks); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), string(readTestdata(t, mode+".txt")); got != want {
+				t.Errorf("profile:\n%s\nwant the one of go tool covdata textfmt:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestBlocksSkip(t *testing.T) {
+	meta, err := ParseMeta(readTestdata(t, "count.covmeta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := meta.Blocks(readTestdata(t, "count.covcounters"), func(file string) bool {
+		return strings.HasSuffix(file, "/main.go")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 0 {
+		t.Errorf("got %d blocks of the skipped file", len(blocks))
+	}
+}
+
+// patch returns a copy of data with the bytes at off replaced
+func patch(data []byte, off int, b ...byte) []byte {
+	res := append([]byte(nil), data...)
+	copy(res[off:], b)
+	return res
+}
+
+func u32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+func TestParseMetaInvalid(t *testing.T) {
+	data := readTestdata(t, "count.covmeta")
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"magic", patch(data, 0, 'x'), "invalid meta-data magic"},
+		{"older version", patch(data, 4, u32(0)...), "unsupported meta-data version 0"},
+		{"newer version", patch(data, 4, u32(metaVersion+1)...), "unsupported meta-data version 2"},
+		{"counter mode", patch(data, 48, 9), "unknown counter mode 9"},
+		{"truncated", data[:len(data)-1], "meta-data length"},
+		{"header only", data[:20], errShort.Error()},
+		{"empty", nil, "invalid meta-data magic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMeta(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBlocksInvalidCounters(t *testing.T) {
+	meta, err := ParseMeta(readTestdata(t, "count.covmeta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := readTestdata(t, "count.covcounters")
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"magic", patch(data, 0, 'x'), "invalid counter data magic"},
+		{"older version", patch(data, 4, u32(0)...), "unsupported counter data version 0"},
+		{"newer version", patch(data, 4, u32(counterVersion+1)...), "unsupported counter data version 2"},
+		{"another program", readTestdata(t, "set.covcounters"), errMismatch.Error()},
+		{"flavor", patch(data, 24, 9), "unknown counter flavor 9"},
+		{"footer", patch(data, len(data)-counterFileFooterSize, 'x'), "invalid counter data footer"},
+		{"header only", data[:10], errShort.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := meta.Blocks(tt.data, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package covdata
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// source is the source of the converter, injected into the programs built with the native backend
+//
+//go:embed covdata.go
+var source string
+
+// Source returns the source of the converter declared in the package pkgName
+func Source(pkgName string) string {
+	return strings.Replace(source, "package covdata\n", "package "+pkgName+"\n", 1)
+}
@@ -12,9 +12,13 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/spelens-gud/golangci-scope/internal/cover/covdata"
 	tool "github.com/spelens-gud/golangci-scope/internal/cover/internal"
 	"github.com/spelens-gud/logger"
 )
@@ -35,14 +39,19 @@ type TestCover struct {
 	DepsCover                []*PackageCover
 	CacheCover               map[string]*PackageCover
 	GlobalCoverVarImportPath string
+	Native                   bool   // serve the counters of runtime/coverage instead of the annotated ones
+	Manifest                 string // the manifest of the program in json
+	PushInterval             string // push the profile to the center on the interval instead of registering, if set
 }
 type PackageCover struct {
 	Package *Package
 	Vars    map[string]*FileVar
 }
 type FileVar struct {
-	File string
-	Var  string
+	File   string
+	Var    string
+	Hash   string // sha256 of the source file, for the manifest
+	Blocks int    // number of the counted blocks
 }
 type Package struct {
 	Dir        string `json:"Dir"`        // directory containing package sources
@@ -94,12 +103,18 @@ type PackageError struct {
 }
 
 func ListPackages(dir string, args string, newgopath string) (map[string]*Package, error) {
+	var env []string
+	if newgopath != "" {
+		env = append(os.Environ(), fmt.Sprintf("GOPATH=%v", newgopath))
+	}
+	return listPackages(dir, args, env)
+}
+
+func listPackages(dir string, args string, env []string) (map[string]*Package, error) {
 	cmd := exec.Command("/bin/bash", "-c", "go list "+args)
 	log.Printf("go list cmd is: %v", cmd.Args)
 	cmd.Dir = dir
-	if newgopath != "" {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GOPATH=%v", newgopath))
-	}
+	cmd.Env = env
 	var errbuf bytes.Buffer
 	cmd.Stderr = &errbuf
 	out, err := cmd.Output()
@@ -140,11 +155,25 @@ type CoverInfo struct {
 	ModRootPath              string
 	GlobalCoverVarImportPath string // path for the injected global cover var file
 	OneMainPackage           bool
+	MainPackages             []string             // import paths of the main packages to inject, empty means all
+	GoWork                   string               // the go.work file in the target, empty if not in workspace mode
+	ExtraPackages            []string             // package patterns of the other modules to cover, relative to Target
+	OverlayFile              string               // write an -overlay file instead of instrumenting Target in place if set
+	Parallelism              int                  // number of packages instrumented concurrently, GOMAXPROCS by default
+	CacheDir                 string               // directory caching the annotated files across builds, no cache if empty
+	IncludePackages          []string             // rules of the packages to instrument, all the packages if empty
+	ExcludePackages          []string             // rules of the packages not to instrument
+	Backend                  string               // "native" leaves the instrumentation to go build -cover, goc by default
+	CoverPackages            []string             // set by Execute to the import paths of the packages to cover, sorted
+	Revision                 string               // VCS revision of the project, recorded in the manifests
+	Modified                 bool                 // whether the working tree has uncommitted changes
+	Manifests                map[string]*Manifest // set by Execute, keyed by the import path of the main packages
 	Args                     string
 	Mode                     string
 	AgentPort                string
 	Center                   string
 	Singleton                bool
+	PushInterval             time.Duration // push the profile to the center on the interval instead of registering, if not 0
 }
 
 func Execute(coverInfo *CoverInfo) error {
@@ -156,6 +185,7 @@ func Execute(coverInfo *CoverInfo) error {
 	agentPort := coverInfo.AgentPort
 	center := coverInfo.Center
 	singleton := coverInfo.Singleton
+	pushInterval := pushIntervalString(coverInfo.PushInterval)
 	globalCoverVarImportPath := coverInfo.GlobalCoverVarImportPath
 
 	if coverInfo.IsMod {
@@ -173,60 +203,215 @@ func Execute(coverInfo *CoverInfo) error {
 		listArgs = append(listArgs, args)
 	}
 	listArgs = append(listArgs, "./...")
-	pkgs, err := ListPackages(target, strings.Join(listArgs, " "), newGopath)
+	// the other modules are not matched by ./... of the main module
+	listArgs = append(listArgs, coverInfo.ExtraPackages...)
+	env := os.Environ()
+	if newGopath != "" {
+		env = append(env, fmt.Sprintf("GOPATH=%v", newGopath))
+	}
+	if coverInfo.GoWork != "" {
+		env = append(env, fmt.Sprintf("GOWORK=%v", coverInfo.GoWork))
+	}
+	pkgs, err := listPackages(target, strings.Join(listArgs, " "), env)
 	if err != nil {
 		logger.Errorf("Fail to list all packages, the error: %v", err)
 		return err
 	}
 
-	var seen = make(map[string]*PackageCover)
-	// var seenCache = make(map[string]*PackageCover)
-	allDecl := ""
+	var overlay *Overlay
+	if coverInfo.OverlayFile != "" {
+		overlay = NewOverlay(filepath.Join(filepath.Dir(coverInfo.OverlayFile), "overlay"))
+	}
+
+	filter, err := NewPackageFilter(coverInfo.IncludePackages, coverInfo.ExcludePackages)
+	if err != nil {
+		return err
+	}
+
+	// collect the selected main packages and their dependencies in the project which match the rules,
+	// each package is instrumented only once and shared by all the main packages
+	var mainPkgs []*Package
+	var coverPkgs = make(map[string]*Package)
 	for _, pkg := range pkgs {
-		if pkg.Name == "main" {
-			logger.Infof("handle package: %v", pkg.ImportPath)
-			// inject the main package
-			mainCover, mainDecl := AddCounters(pkg, mode, globalCoverVarImportPath)
-			allDecl += mainDecl
-			// new a testcover for this service
-			tc := TestCover{
-				Mode:                     mode,
-				AgentPort:                agentPort,
-				Center:                   center,
-				Singleton:                singleton,
-				MainPkgCover:             mainCover,
-				GlobalCoverVarImportPath: globalCoverVarImportPath,
+		if pkg.Name != "main" {
+			continue
+		}
+		if len(coverInfo.MainPackages) != 0 && !contains(coverInfo.MainPackages, pkg.ImportPath) {
+			continue
+		}
+		mainPkgs = append(mainPkgs, pkg)
+		if filter.Match(pkg.ImportPath) {
+			coverPkgs[pkg.ImportPath] = pkg
+		}
+		for _, dep := range pkg.Deps {
+			//only focus package neither standard Go library nor dependency library
+			if depPkg, ok := pkgs[dep]; ok && filter.Match(dep) {
+				coverPkgs[dep] = depPkg
 			}
+		}
+	}
+	sort.Slice(mainPkgs, func(i, j int) bool { return mainPkgs[i].ImportPath < mainPkgs[j].ImportPath })
+	if len(mainPkgs) != 0 && len(coverPkgs) == 0 {
+		logger.Errorf("no package left to instrument by the rules, include: %v, exclude: %v", coverInfo.IncludePackages, coverInfo.ExcludePackages)
+		return ErrCoverPkgFailed
+	}
 
-			// handle its dependency
-			// var internalPkgCache = make(map[string][]*PackageCover)
-			tc.CacheCover = make(map[string]*PackageCover)
-			for _, dep := range pkg.Deps {
-				if packageCover, ok := seen[dep]; ok {
-					tc.DepsCover = append(tc.DepsCover, packageCover)
-					continue
-				}
+	if coverInfo.Backend == "native" {
+		return executeNative(coverInfo, mainPkgs, coverPkgs, globalCoverVarImportPath, overlay)
+	}
 
-				//only focus package neither standard Go library nor dependency library
-				if depPkg, ok := pkgs[dep]; ok {
-					packageCover, depDecl := AddCounters(depPkg, mode, globalCoverVarImportPath)
-					allDecl += depDecl
-					tc.DepsCover = append(tc.DepsCover, packageCover)
-					seen[dep] = packageCover
-				}
+	var cache *Cache
+	if coverInfo.CacheDir != "" {
+		if cache, err = NewCache(coverInfo.CacheDir); err != nil {
+			return err
+		}
+	}
+
+	covers, allDecl, err := addCountersParallel(coverPkgs, mode, globalCoverVarImportPath, overlay, cache, coverInfo.Parallelism)
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range mainPkgs {
+		logger.Infof("handle package: %v", pkg.ImportPath)
+		// the http apis are injected even if the main package itself is excluded
+		mainPkgCover, ok := covers[pkg.ImportPath]
+		if !ok {
+			mainPkgCover = &PackageCover{Package: pkg}
+		}
+		// new a testcover for this service
+		tc := TestCover{
+			Mode:                     mode,
+			AgentPort:                agentPort,
+			Center:                   center,
+			Singleton:                singleton,
+			PushInterval:             pushInterval,
+			MainPkgCover:             mainPkgCover,
+			GlobalCoverVarImportPath: globalCoverVarImportPath,
+			CacheCover:               make(map[string]*PackageCover),
+		}
+		// handle its dependency
+		for _, dep := range pkg.Deps {
+			if packageCover, ok := covers[dep]; ok {
+				tc.DepsCover = append(tc.DepsCover, packageCover)
 			}
+		}
+		if tc.Manifest, err = addManifest(coverInfo, pkg, append([]*PackageCover{mainPkgCover}, tc.DepsCover...)); err != nil {
+			return err
+		}
+
+		// inject Http Cover APIs
+		httpCoverApis, err := overlay.Path(fmt.Sprintf("%s/http_cover_apis_auto_generated.go", pkg.Dir))
+		if err != nil {
+			return err
+		}
+		if err := InjectCountersHandlers(tc, httpCoverApis); err != nil {
+			logger.Errorf("failed to inject counters for package: %s, err: %v", pkg.ImportPath, err)
+			return ErrCoverPkgFailed
+		}
+		if err := injectExitFlush(pkg, overlay); err != nil {
+			logger.Errorf("failed to inject the exit dump for package: %s, err: %v", pkg.ImportPath, err)
+			return ErrCoverPkgFailed
+		}
+	}
 
-			// inject Http Cover APIs
-			var httpCoverApis = fmt.Sprintf("%s/http_cover_apis_auto_generated.go", pkg.Dir)
-			if err := InjectCountersHandlers(tc, httpCoverApis); err != nil {
-				logger.Errorf("failed to inject counters for package: %s, err: %v", pkg.ImportPath, err)
-				return ErrCoverPkgFailed
+	globalCoverVarFile, err := overlay.Path(filepath.Join(target, coverInfo.GlobalCoverVarImportPath, "cover.go"))
+	if err != nil {
+		return err
+	}
+	if mode == "branch" {
+		allDecl += tool.BranchFuncDecl
+	}
+	if err := injectGlobalCoverVarFile(globalCoverVarFile, filepath.Base(coverInfo.GlobalCoverVarImportPath), allDecl); err != nil {
+		return err
+	}
+	if overlay != nil {
+		return overlay.WriteFile(coverInfo.OverlayFile)
+	}
+	return nil
+}
+
+// executeNative injects the http apis and the converter of runtime/coverage only,
+// the packages to cover are left to the toolchain and reported in coverInfo.CoverPackages
+func executeNative(coverInfo *CoverInfo, mainPkgs []*Package, coverPkgs map[string]*Package, globalCoverVarImportPath string, overlay *Overlay) error {
+	coverInfo.CoverPackages = coverInfo.CoverPackages[:0]
+	covers := make(map[string]*PackageCover)
+	for importPath, pkg := range coverPkgs {
+		coverInfo.CoverPackages = append(coverInfo.CoverPackages, importPath)
+		// the toolchain names the cover variables itself
+		pc := &PackageCover{Package: pkg, Vars: declareCoverVars(pkg)}
+		for _, fv := range pc.Vars {
+			fv.Var = ""
+		}
+		if err := hashPackageFiles(pc); err != nil {
+			return err
+		}
+		covers[importPath] = pc
+	}
+	sort.Strings(coverInfo.CoverPackages)
+
+	for _, pkg := range mainPkgs {
+		logger.Infof("handle package: %v", pkg.ImportPath)
+		var pkgCovers []*PackageCover
+		for _, importPath := range append([]string{pkg.ImportPath}, pkg.Deps...) {
+			if pc, ok := covers[importPath]; ok {
+				pkgCovers = append(pkgCovers, pc)
 			}
 		}
+		manifest, err := addManifest(coverInfo, pkg, pkgCovers)
+		if err != nil {
+			return err
+		}
+		tc := TestCover{
+			Mode:                     coverInfo.Mode,
+			AgentPort:                coverInfo.AgentPort,
+			Center:                   coverInfo.Center,
+			Singleton:                coverInfo.Singleton,
+			PushInterval:             pushIntervalString(coverInfo.PushInterval),
+			MainPkgCover:             &PackageCover{Package: pkg},
+			GlobalCoverVarImportPath: globalCoverVarImportPath,
+			CacheCover:               make(map[string]*PackageCover),
+			Native:                   true,
+			Manifest:                 manifest,
+		}
+		httpCoverApis, err := overlay.Path(fmt.Sprintf("%s/http_cover_apis_auto_generated.go", pkg.Dir))
+		if err != nil {
+			return err
+		}
+		if err := InjectCountersHandlers(tc, httpCoverApis); err != nil {
+			logger.Errorf("failed to inject counters for package: %s, err: %v", pkg.ImportPath, err)
+			return ErrCoverPkgFailed
+		}
+		if err := injectExitFlush(pkg, overlay); err != nil {
+			logger.Errorf("failed to inject the exit dump for package: %s, err: %v", pkg.ImportPath, err)
+			return ErrCoverPkgFailed
+		}
 	}
 
-	return injectGlobalCoverVarFile(coverInfo, allDecl)
+	converterFile, err := overlay.Path(filepath.Join(coverInfo.Target, coverInfo.GlobalCoverVarImportPath, "covdata.go"))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(converterFile, []byte(covdata.Source(filepath.Base(coverInfo.GlobalCoverVarImportPath))), 0644); err != nil {
+		return err
+	}
+	if overlay != nil {
+		return overlay.WriteFile(coverInfo.OverlayFile)
+	}
+	return nil
+}
+
+// addManifest records the manifest of the main package in coverInfo.Manifests and returns it in json
+func addManifest(coverInfo *CoverInfo, mainPkg *Package, covers []*PackageCover) (string, error) {
+	manifest := newManifest(coverInfo, mainPkg, covers)
+	if coverInfo.Manifests == nil {
+		coverInfo.Manifests = make(map[string]*Manifest)
+	}
+	coverInfo.Manifests[mainPkg.ImportPath] = manifest
+	content, err := manifest.JSON()
+	return string(content), err
 }
+
 func isDirExist(path string) bool {
 	s, err := os.Stat(path)
 	if err != nil {
@@ -235,19 +420,112 @@ func isDirExist(path string) bool {
 	return s.IsDir()
 }
 
-func AddCounters(pkg *Package, mode string, globalCoverVarImportPath string) (*PackageCover, string) {
+// addCountersParallel adds counters to the packages with a bounded worker pool,
+// it returns the covers by import path and all the cover variables declarations.
+// The errors of all the files are collected and reported together.
+func addCountersParallel(pkgs map[string]*Package, mode string, globalCoverVarImportPath string, overlay *Overlay, cache *Cache, parallelism int) (map[string]*PackageCover, string, error) {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	importPaths := make([]string, 0, len(pkgs))
+	for importPath := range pkgs {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		errs   []error
+		covers = make(map[string]*PackageCover, len(pkgs))
+		decls  = make(map[string]string, len(pkgs))
+		jobs   = make(chan string)
+	)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for importPath := range jobs {
+				packageCover, decl, err := AddCounters(pkgs[importPath], mode, globalCoverVarImportPath, overlay, cache)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					covers[importPath] = packageCover
+					decls[importPath] = decl
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, importPath := range importPaths {
+		jobs <- importPath
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(errs) != 0 {
+		err := errors.Join(errs...)
+		logger.Errorf("failed to inject counters:\n%v", err)
+		return nil, "", fmt.Errorf("%w: %w", ErrCoverPkgFailed, err)
+	}
+
+	var allDecl strings.Builder
+	for _, importPath := range importPaths {
+		allDecl.WriteString(decls[importPath])
+	}
+	return covers, allDecl.String(), nil
+}
+
+// AddCounters adds counters to all the files of the package,
+// the errors of the files are joined together.
+// The unchanged files are taken from the cache if it is not nil.
+func AddCounters(pkg *Package, mode string, globalCoverVarImportPath string, overlay *Overlay, cache *Cache) (*PackageCover, string, error) {
 	coverVarMap := declareCoverVars(pkg)
 
+	files := make([]string, 0, len(coverVarMap))
+	for file := range coverVarMap {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	var errs []error
 	decl := ""
-	for file, coverVar := range coverVarMap {
-		decl += "\n" + tool.Annotate(path.Join(pkg.Dir, file), mode, coverVar.Var, globalCoverVarImportPath) + "\n"
+	for _, file := range files {
+		name := path.Join(pkg.Dir, file)
+		dest, err := overlay.Path(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// hash the source before it is annotated in place
+		if coverVarMap[file].Hash, err = fileHash(name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fileDecl, err := cache.Annotate(name, dest, mode, coverVarMap[file].Var, globalCoverVarImportPath)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if fileDecl == "" {
+			// generated or ignored file, it is neither counted nor reported
+			delete(coverVarMap, file)
+			continue
+		}
+		coverVarMap[file].Blocks = blockCount(fileDecl)
+		decl += "\n" + fileDecl + "\n"
+	}
+	if len(errs) != 0 {
+		return nil, "", fmt.Errorf("package %s: %w", pkg.ImportPath, errors.Join(errs...))
 	}
 
 	return &PackageCover{
 		Package: pkg,
 		Vars:    coverVarMap,
-	}, decl
+	}, decl, nil
 }
+
 func declareCoverVars(p *Package) map[string]*FileVar {
 	coverVars := make(map[string]*FileVar)
 	coverIndex := 0
@@ -0,0 +1,227 @@
+package cover
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// hunkRe matches the hunk header of a unified diff
+var hunkRe = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// ChangedLines returns the lines of the go files changed in the working tree of the git repository
+// containing dir since it forked from base, keyed by the path relative to the repository root
+func ChangedLines(dir string, base string) (root string, changed map[string][]int, err error) {
+	out, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", nil, err
+	}
+	root = strings.TrimSpace(string(out))
+	out, err = git(dir, "merge-base", base, "HEAD")
+	if err != nil {
+		return "", nil, err
+	}
+	mergeBase := strings.TrimSpace(string(out))
+	// the prefixes are given explicitly, diff.noprefix and diff.mnemonicPrefix in the git config change them
+	out, err = git(root, "diff", "--unified=0", "--no-color", "--no-ext-diff", "--no-renames",
+		"--src-prefix=a/", "--dst-prefix=b/", mergeBase, "--", "*.go")
+	if err != nil {
+		return "", nil, err
+	}
+	changed, err = parseDiff(out)
+	return root, changed, err
+}
+
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("fail to execute `git %s`, err: %w, stderr: %v", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// parseDiff collects the added and modified lines of the new files in the unified diff
+func parseDiff(diff []byte) (map[string][]int, error) {
+	changed := make(map[string][]int)
+	file := ""
+	// the removed and added lines left in the current hunk, they may look like the headers
+	pending := 0
+	scanner := bufio.NewScanner(bytes.NewReader(diff))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if pending > 0 && (strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+")) {
+			pending--
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			name, err := diffFileName(strings.TrimPrefix(line, "+++ "))
+			if err != nil {
+				return nil, err
+			}
+			file = name
+		case strings.HasPrefix(line, "@@ "):
+			match := hunkRe.FindStringSubmatch(line)
+			if match == nil {
+				return nil, fmt.Errorf("invalid hunk header: %s", line)
+			}
+			removed, start, count := hunkCount(match[1]), atoi(match[2]), hunkCount(match[3])
+			pending = removed + count
+			if file == "" {
+				continue
+			}
+			for i := 0; i < count; i++ {
+				changed[file] = append(changed[file], start+i)
+			}
+		}
+	}
+	return changed, scanner.Err()
+}
+
+// diffFileName returns the path of the new file in the header, empty for a deleted file.
+// The path must have the b/ prefix, a diff with other prefixes would drop all the files silently.
+func diffFileName(name string) (string, error) {
+	// the deleted files have nothing to cover
+	if name == "/dev/null" {
+		return "", nil
+	}
+	// git quotes the paths with special characters in the C style
+	if strings.HasPrefix(name, `"`) {
+		unquoted, err := strconv.Unquote(name)
+		if err != nil {
+			return "", fmt.Errorf("invalid file header: %s", name)
+		}
+		name = unquoted
+	}
+	if !strings.HasPrefix(name, "b/") {
+		return "", fmt.Errorf("file header without the b/ prefix: %s", name)
+	}
+	return strings.TrimPrefix(name, "b/"), nil
+}
+
+// hunkCount returns the number of lines of a side of the hunk, which is 1 if it is omitted
+func hunkCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	return atoi(s)
+}
+
+// atoi converts the digits matched by hunkRe
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// FileDiffCoverage is the coverage of the changed lines of a file,
+// the changed lines which are not in any block, such as comments and declarations, are not counted
+type FileDiffCoverage struct {
+	File      string `json:"file"` // path relative to the repository root
+	Covered   []int  `json:"covered"`
+	Uncovered []int  `json:"uncovered"`
+}
+
+// DiffCoverage is the coverage of the changed lines
+type DiffCoverage struct {
+	Files     []FileDiffCoverage `json:"files"`
+	Covered   int                `json:"covered"`
+	Uncovered int                `json:"uncovered"`
+}
+
+// Percent returns the percentage of the covered changed lines, 100 if no line is counted
+func (d *DiffCoverage) Percent() float64 {
+	if d.Covered+d.Uncovered == 0 {
+		return 100
+	}
+	return float64(d.Covered) * 100 / float64(d.Covered+d.Uncovered)
+}
+
+// IntersectDiff intersects the changed lines in the repository at root with the blocks of the profiles,
+// a changed line is covered if any block on it is executed
+func IntersectDiff(profiles []*cover.Profile, checkout *Checkout, root string, changed map[string][]int) (*DiffCoverage, error) {
+	// the blocks of each file by the path relative to the repository root
+	blocks := make(map[string][]cover.ProfileBlock)
+	for _, p := range profiles {
+		if IsBranchFile(p.FileName) {
+			continue
+		}
+		local, ok := checkout.Path(sourceFileName(p.FileName))
+		if !ok {
+			continue
+		}
+		rel, err := filepath.Rel(root, local)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+		blocks[rel] = append(blocks[rel], p.Blocks...)
+	}
+
+	files := make([]string, 0, len(changed))
+	for file := range changed {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	d := &DiffCoverage{Files: []FileDiffCoverage{}}
+	for _, file := range files {
+		fileBlocks, ok := blocks[file]
+		if !ok {
+			continue
+		}
+		fc := FileDiffCoverage{File: file, Covered: []int{}, Uncovered: []int{}}
+		for _, line := range changed[file] {
+			counted, covered := false, false
+			for _, b := range fileBlocks {
+				if b.StartLine <= line && line <= b.EndLine {
+					counted = true
+					covered = covered || b.Count > 0
+				}
+			}
+			switch {
+			case covered:
+				fc.Covered = append(fc.Covered, line)
+			case counted:
+				fc.Uncovered = append(fc.Uncovered, line)
+			}
+		}
+		if len(fc.Covered)+len(fc.Uncovered) == 0 {
+			continue
+		}
+		d.Files = append(d.Files, fc)
+		d.Covered += len(fc.Covered)
+		d.Uncovered += len(fc.Uncovered)
+	}
+	return d, nil
+}
+
+// LineRanges formats the sorted lines as ranges, such as 3-5,9
+func LineRanges(lines []int) string {
+	var ranges []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(lines[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
@@ -0,0 +1,301 @@
+package cover
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+func TestParseDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		diff string
+		want map[string][]int
+	}{
+		{
+			name: "modified",
+			diff: `diff --git a/app/main.go b/app/main.go
+index 1111111..2222222 100644
+--- a/app/main.go
++++ b/app/main.go
+@@ -3 +3 @@ import "fmt"
+-	fmt.Println("a")
++	fmt.Println("b")
+@@ -10,2 +10,3 @@ func main() {
+-	x := 1
+-	y := 2
++	x := 3
++	y := 4
++	z := 5
+`,
+			want: map[string][]int{"app/main.go": {3, 10, 11, 12}},
+		},
+		{
+			name: "pure insertion",
+			diff: `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -5,0 +6 @@ func main() {
++	x++
+@@ -8,0 +10,2 @@ func main() {
++	y++
++	z++
+`,
+			want: map[string][]int{"main.go": {6, 10, 11}},
+		},
+		{
+			name: "pure deletion",
+			diff: `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -6 +5,0 @@ func main() {
+-	x++
+@@ -9,2 +7,0 @@ func main() {
+-	y++
+-	z++
+`,
+			want: map[string][]int{},
+		},
+		{
+			name: "added file",
+			diff: `diff --git a/new.go b/new.go
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/new.go
+@@ -0,0 +1,3 @@
++package main
++
++func f() {}
+`,
+			want: map[string][]int{"new.go": {1, 2, 3}},
+		},
+		{
+			name: "deleted file",
+			diff: `diff --git a/old.go b/old.go
+deleted file mode 100644
+index 3333333..0000000
+--- a/old.go
++++ /dev/null
+@@ -1,2 +0,0 @@
+-package main
+-func f() {}
+diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -4 +4 @@
+-	f()
++	g()
+`,
+			want: map[string][]int{"main.go": {4}},
+		},
+		{
+			name: "renamed without change",
+			diff: `diff --git a/old.go b/new.go
+similarity index 100%
+rename from old.go
+rename to new.go
+`,
+			want: map[string][]int{},
+		},
+		{
+			name: "renamed with change",
+			diff: `diff --git a/old.go b/pkg/new.go
+similarity index 90%
+rename from old.go
+rename to pkg/new.go
+index 1111111..2222222 100644
+--- a/old.go
++++ b/pkg/new.go
+@@ -7 +7,2 @@ func f() {
+-	return 1
++	x := 2
++	return x
+`,
+			want: map[string][]int{"pkg/new.go": {7, 8}},
+		},
+		{
+			name: "quoted path",
+			diff: `diff --git "a/caf\303\251.go" "b/caf\303\251.go"
+--- "a/caf\303\251.go"
++++ "b/caf\303\251.go"
+@@ -1 +1 @@
+-package a
++package b
+`,
+			want: map[string][]int{"café.go": {1}},
+		},
+		{
+			name: "lines looking like the headers",
+			diff: `diff --git a/doc.go b/doc.go
+--- a/doc.go
++++ b/doc.go
+@@ -2,2 +2,2 @@
+--- removed
+-@@ removed
++++ added
++@@ -1 +1 @@
+\ No newline at end of file
+`,
+			want: map[string][]int{"doc.go": {2, 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDiff([]byte(tt.diff))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDiff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDiffInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		diff string
+	}{
+		{"hunk header", "--- a/main.go\n+++ b/main.go\n@@ -1 +x @@\n"},
+		{"no prefix", "--- main.go\n+++ main.go\n@@ -1 +1 @@\n-a\n+b\n"},
+		{"mnemonic prefix", "--- i/main.go\n+++ w/main.go\n@@ -1 +1 @@\n-a\n+b\n"},
+		{"quoted path", "--- a/main.go\n+++ \"b/main.go\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseDiff([]byte(tt.diff)); err == nil {
+				t.Errorf("no error for the diff:\n%s", tt.diff)
+			}
+		})
+	}
+}
+
+func TestChangedLines(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		if _, err := git(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q", "-b", "main")
+	run("config", "user.name", "test")
+	run("config", "user.email", "test@example.com")
+	run("config", "commit.gpgsign", "false")
+	// the porcelain git diff drops or renames the a/ and b/ prefixes by these
+	run("config", "diff.noprefix", "true")
+	run("config", "diff.mnemonicPrefix", "true")
+	write("main.go", "package main\n\nfunc main() {\n}\n")
+	write("old.go", "package main\n")
+	write("README.md", "readme\n")
+	run("add", "-A")
+	run("commit", "-q", "-m", "base")
+	run("checkout", "-q", "-b", "feature")
+	write("main.go", "package main\n\nfunc main() {\n\tprintln()\n}\n")
+	write("README.md", "changed\n")
+	run("rm", "-q", "old.go")
+
+	root, changed, err := ChangedLines(dir, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := filepath.EvalSymlinks(dir); root != want && root != dir {
+		t.Errorf("root %s, want %s", root, dir)
+	}
+	if want := map[string][]int{"main.go": {4}}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("ChangedLines() = %v, want %v", changed, want)
+	}
+}
+
+func TestIntersectDiff(t *testing.T) {
+	// the module is in the app directory of the repository
+	checkout := &Checkout{Root: "/repo/app", Module: "example.com/app"}
+	profiles := []*cover.Profile{
+		{
+			FileName: "example.com/app/main.go",
+			Mode:     "count",
+			Blocks: []cover.ProfileBlock{
+				{StartLine: 3, StartCol: 13, EndLine: 6, EndCol: 2, NumStmt: 2, Count: 1},
+				{StartLine: 6, StartCol: 2, EndLine: 8, EndCol: 3, NumStmt: 1, Count: 0},
+				{StartLine: 10, StartCol: 2, EndLine: 12, EndCol: 3, NumStmt: 1, Count: 0},
+			},
+		},
+		{
+			// branch outcomes are not lines
+			FileName: "example.com/app/main.go" + branchTrueSuffix,
+			Mode:     "count",
+			Blocks:   []cover.ProfileBlock{{StartLine: 20, StartCol: 5, EndLine: 20, EndCol: 9, NumStmt: 1, Count: 1}},
+		},
+		{
+			FileName: "example.com/app/util/util.go",
+			Mode:     "count",
+			Blocks:   []cover.ProfileBlock{{StartLine: 5, StartCol: 20, EndLine: 7, EndCol: 2, NumStmt: 1, Count: 0}},
+		},
+		{
+			// not in the module
+			FileName: "example.com/lib/lib.go",
+			Mode:     "count",
+			Blocks:   []cover.ProfileBlock{{StartLine: 1, StartCol: 1, EndLine: 9, EndCol: 2, NumStmt: 1, Count: 1}},
+		},
+	}
+	changed := map[string][]int{
+		"app/main.go":      {1, 4, 6, 7, 11, 20},
+		"app/util/util.go": {1, 2},
+		"app/new.go":       {1, 2, 3},
+		"README.md":        {1},
+	}
+
+	d, err := IntersectDiff(profiles, checkout, "/repo", changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DiffCoverage{
+		Files: []FileDiffCoverage{
+			// line 6 is covered by the first block though the second block on it is not executed
+			{File: "app/main.go", Covered: []int{4, 6}, Uncovered: []int{7, 11}},
+		},
+		Covered:   2,
+		Uncovered: 2,
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("IntersectDiff() = %+v, want %+v", d, want)
+	}
+	if p := d.Percent(); p != 50 {
+		t.Errorf("Percent() = %v, want 50", p)
+	}
+	if p := (&DiffCoverage{}).Percent(); p != 100 {
+		t.Errorf("Percent() of no counted line = %v, want 100", p)
+	}
+}
+
+func TestLineRanges(t *testing.T) {
+	tests := []struct {
+		lines []int
+		want  string
+	}{
+		{nil, ""},
+		{[]int{3}, "3"},
+		{[]int{3, 4, 5, 9}, "3-5,9"},
+		{[]int{1, 3, 4}, "1,3-4"},
+	}
+	for _, tt := range tests {
+		if got := LineRanges(tt.lines); got != tt.want {
+			t.Errorf("LineRanges(%v) = %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
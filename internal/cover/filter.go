@@ -0,0 +1,107 @@
+package cover
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// regexpRulePrefix marks a package rule as a regular expression, the others are globs
+const regexpRulePrefix = "re:"
+
+// PackageFilter decides which packages are instrumented by their import paths.
+// A package is instrumented if it matches any include rule, or there is no include rule,
+// and it matches no exclude rule.
+//
+// A rule is a glob unless it starts with "re:", in which case the rest is a regular expression
+// matching a part of the import path. In a glob, '*' matches any sequence of characters except '/',
+// '**' matches any sequence of characters, and a trailing "/..." matches the package and all its
+// subpackages as in the go command.
+type PackageFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// NewPackageFilter compiles the include and exclude rules
+func NewPackageFilter(include, exclude []string) (*PackageFilter, error) {
+	f := &PackageFilter{}
+	var err error
+	if f.include, err = compileRules(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compileRules(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Match reports whether the package should be instrumented,
+// a nil filter matches all the packages
+func (f *PackageFilter) Match(importPath string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) != 0 && !matchAny(f.include, importPath) {
+		return false
+	}
+	return !matchAny(f.exclude, importPath)
+}
+
+func matchAny(rules []*regexp.Regexp, importPath string) bool {
+	for _, rule := range rules {
+		if rule.MatchString(importPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileRules(rules []string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		expr := globToRegexp(rule)
+		if strings.HasPrefix(rule, regexpRulePrefix) {
+			expr = strings.TrimPrefix(rule, regexpRulePrefix)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid package rule %q: %w", rule, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+// globToRegexp translates a package glob to an anchored regular expression
+func globToRegexp(glob string) string {
+	var suffix string
+	if strings.HasSuffix(glob, "/...") {
+		glob = strings.TrimSuffix(glob, "/...")
+		suffix = "(/.*)?"
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case strings.HasPrefix(glob[i:], "..."):
+			b.WriteString(".*")
+			i += 2
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString(suffix)
+	b.WriteString("$")
+	return b.String()
+}
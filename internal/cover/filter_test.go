@@ -0,0 +1,145 @@
+package cover
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob     string
+		expr     string
+		match    []string
+		notMatch []string
+	}{
+		{
+			glob:     "example.com/app/*",
+			expr:     `^example\.com/app/[^/]*$`,
+			match:    []string{"example.com/app/api", "example.com/app/"},
+			notMatch: []string{"example.com/app", "example.com/app/api/v1", "exampleXcom/app/api"},
+		},
+		{
+			glob:     "example.com/app/**",
+			expr:     `^example\.com/app/.*$`,
+			match:    []string{"example.com/app/api", "example.com/app/api/v1"},
+			notMatch: []string{"example.com/app", "example.com/apps/api"},
+		},
+		{
+			glob:     "example.com/app/...",
+			expr:     `^example\.com/app(/.*)?$`,
+			match:    []string{"example.com/app", "example.com/app/api", "example.com/app/api/v1"},
+			notMatch: []string{"example.com/apps", "example.com/apps/api"},
+		},
+		{
+			glob:     "example.com/**/mock",
+			expr:     `^example\.com/.*/mock$`,
+			match:    []string{"example.com/app/mock", "example.com/app/api/mock"},
+			notMatch: []string{"example.com/mock", "example.com/app/mocks"},
+		},
+		{
+			glob:     "example.com/.../mock",
+			expr:     `^example\.com/.*/mock$`,
+			match:    []string{"example.com/app/mock", "example.com/app/api/mock"},
+			notMatch: []string{"example.com/mock"},
+		},
+		{
+			glob:     "example.com/app/v?",
+			expr:     `^example\.com/app/v[^/]$`,
+			match:    []string{"example.com/app/v1"},
+			notMatch: []string{"example.com/app/v10", "example.com/app/v/"},
+		},
+		{
+			glob:     "example.com/app+x",
+			expr:     `^example\.com/app\+x$`,
+			match:    []string{"example.com/app+x"},
+			notMatch: []string{"example.com/appx"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			expr := globToRegexp(tt.glob)
+			if expr != tt.expr {
+				t.Fatalf("globToRegexp(%q) = %q, want %q", tt.glob, expr, tt.expr)
+			}
+			re := regexp.MustCompile(expr)
+			for _, path := range tt.match {
+				if !re.MatchString(path) {
+					t.Errorf("%q does not match %q", tt.glob, path)
+				}
+			}
+			for _, path := range tt.notMatch {
+				if re.MatchString(path) {
+					t.Errorf("%q matches %q", tt.glob, path)
+				}
+			}
+		})
+	}
+}
+
+func TestPackageFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		match    []string
+		notMatch []string
+	}{
+		{
+			name:  "no rule",
+			match: []string{"example.com/app", "example.com/app/api"},
+		},
+		{
+			name:     "include",
+			include:  []string{"example.com/app/..."},
+			match:    []string{"example.com/app", "example.com/app/api"},
+			notMatch: []string{"example.com/lib"},
+		},
+		{
+			name:     "exclude wins over include",
+			include:  []string{"example.com/app/..."},
+			exclude:  []string{"example.com/app/**/mock", " "},
+			match:    []string{"example.com/app/api"},
+			notMatch: []string{"example.com/app/api/mock", "example.com/lib"},
+		},
+		{
+			name:     "regular expression",
+			exclude:  []string{"re:/internal(/|$)"},
+			match:    []string{"example.com/app", "example.com/app/internals"},
+			notMatch: []string{"example.com/app/internal", "example.com/app/internal/db"},
+		},
+		{
+			name:     "regular expression is not a glob",
+			include:  []string{"re:app/*"},
+			match:    []string{"example.com/app", "example.com/lib/app/x"},
+			notMatch: []string{"example.com/lib"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewPackageFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range tt.match {
+				if !f.Match(path) {
+					t.Errorf("%q is not instrumented", path)
+				}
+			}
+			for _, path := range tt.notMatch {
+				if f.Match(path) {
+					t.Errorf("%q is instrumented", path)
+				}
+			}
+		})
+	}
+}
+
+func TestPackageFilterInvalid(t *testing.T) {
+	if _, err := NewPackageFilter([]string{"re:("}, nil); err == nil {
+		t.Error("no error for an invalid regular expression")
+	}
+	var f *PackageFilter
+	if !f.Match("example.com/app") {
+		t.Error("a nil filter does not match")
+	}
+}
@@ -0,0 +1,187 @@
+package cover
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spelens-gud/logger"
+)
+
+// the health status of the instances in /v1/cover/list?health=true
+const (
+	HealthUnknown   = "unknown" // not probed yet
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+	HealthPushed    = "pushed"  // the instance pushes its profile, it is unhealthy once it misses its pushes instead of being probed
+	HealthExpired   = "expired" // the merged profiles of the expired pushing instances of the service
+)
+
+// probeTimeout bounds a probe, an agent not answering in time is unhealthy
+const probeTimeout = 5 * time.Second
+
+// ServiceHealth is the health of an instance of a service, as seen by the center
+type ServiceHealth struct {
+	Name           string     `json:"name"`
+	Address        string     `json:"address"`
+	Status         string     `json:"status"`
+	LastSeen       *time.Time `json:"last_seen,omitempty"`       // the last successful probe, or the last push
+	UnhealthySince *time.Time `json:"unhealthy_since,omitempty"` // the first failed probe since the last successful one
+	Error          string     `json:"error,omitempty"`           // why the last probe failed
+}
+
+// probeState is the result of the probes of an address
+type probeState struct {
+	lastSeen       time.Time
+	unhealthySince time.Time
+	err            string
+}
+
+// healthStates keeps the probe states of the registered addresses
+type healthStates struct {
+	mu     sync.RWMutex
+	states map[string]*probeState
+}
+
+func (h *healthStates) get(address string) (probeState, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	state, ok := h.states[address]
+	if !ok {
+		return probeState{}, false
+	}
+	return *state, true
+}
+
+// update records the result of a probe, and returns the state of the address after it
+func (h *healthStates) update(address string, now time.Time, err error) probeState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.states == nil {
+		h.states = make(map[string]*probeState)
+	}
+	state, ok := h.states[address]
+	if !ok {
+		state = &probeState{}
+		h.states[address] = state
+	}
+	if err == nil {
+		state.lastSeen, state.unhealthySince, state.err = now, time.Time{}, ""
+	} else {
+		if state.unhealthySince.IsZero() {
+			state.unhealthySince = now
+		}
+		state.err = err.Error()
+	}
+	return *state
+}
+
+// retain drops the states of the addresses no longer registered
+func (h *healthStates) retain(services map[string][]string) {
+	registered := make(map[string]bool)
+	for _, addrs := range services {
+		for _, addr := range addrs {
+			registered[addr] = true
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for addr := range h.states {
+		if !registered[addr] {
+			delete(h.states, addr)
+		}
+	}
+}
+
+func (h *healthStates) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.states = nil
+}
+
+// probeLoop probes the registered addresses on every ProbeInterval until the server stops
+func (s *server) probeLoop() {
+	ticker := time.NewTicker(s.ProbeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.probeAll()
+	}
+}
+
+// probeAll probes all the registered addresses concurrently, marks the ones not answering unhealthy,
+// and removes the ones unhealthy for longer than EvictAfter, the pushing instances missing their pushes
+// for longer than it are merged into the expired profile of their services
+func (s *server) probeAll() {
+	if s.EvictAfter > 0 {
+		s.pushed.expire(s.clock(), s.EvictAfter)
+	}
+
+	services := s.Store.GetAll()
+	s.health.retain(services)
+
+	var wg sync.WaitGroup
+	for name, addrs := range services {
+		for _, addr := range addrs {
+			wg.Add(1)
+			go func(name, addr string) {
+				defer wg.Done()
+				now := s.clock()
+				state := s.health.update(addr, now, probe(addr))
+				if state.unhealthySince.IsZero() || s.EvictAfter <= 0 || now.Sub(state.unhealthySince) < s.EvictAfter {
+					return
+				}
+				if err := s.Store.Remove(addr); err != nil {
+					logger.Errorf("failed to evict the unhealthy service %s, address %s, err: %v", name, addr, err)
+					return
+				}
+				logger.Warnf("evicted the service %s, address %s, unhealthy since %s, err: %s", name, addr, state.unhealthySince.Format(time.RFC3339), state.err)
+			}(name, addr)
+		}
+	}
+	wg.Wait()
+}
+
+// probe checks whether the agent at the address answers, any response means it is alive
+func probe(address string) error {
+	client := http.Client{Timeout: probeTimeout}
+	resp, err := client.Get(address + CoverManifestAPI)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// servicesHealth returns the health of the registered and pushing instances, sorted by name and address
+func (s *server) servicesHealth() []ServiceHealth {
+	res := []ServiceHealth{}
+	for name, addrs := range s.allServices() {
+		for _, addr := range addrs {
+			h := ServiceHealth{Name: name, Address: addr, Status: HealthUnknown}
+			if isPushAddress(addr) {
+				if pushed, ok := s.pushed.get(addr); ok {
+					h.Status, h.LastSeen = HealthPushed, &pushed.Time
+					if isExpiredAddress(addr) {
+						h.Status = HealthExpired
+					} else if since := pushed.staleSince(s.clock()); !since.IsZero() {
+						h.Status, h.UnhealthySince = HealthUnhealthy, &since
+					}
+				}
+			} else if state, ok := s.health.get(addr); ok {
+				h.Status, h.Error = HealthHealthy, state.err
+				if !state.lastSeen.IsZero() {
+					h.LastSeen = &state.lastSeen
+				}
+				if !state.unhealthySince.IsZero() {
+					h.Status, h.UnhealthySince = HealthUnhealthy, &state.unhealthySince
+				}
+			}
+			res = append(res, h)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name || res[i].Name == res[j].Name && res[i].Address < res[j].Address
+	})
+	return res
+}
@@ -0,0 +1,96 @@
+package cover
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProbeEvicts(t *testing.T) {
+	s := NewMemoryBasedServer()
+	s.ProbeInterval, s.EvictAfter = time.Second, time.Minute
+	c := newTestCenter(t, s)
+	alive := newFakeAgent(t, "mode: count\n")
+	dead := newFakeAgent(t, "mode: count\n")
+	c.register("alive", alive.URL)
+	c.register("dead", dead.URL)
+
+	statuses := func() map[string]string {
+		res := make(map[string]string)
+		for _, h := range c.health() {
+			res[h.Address] = h.Status
+		}
+		return res
+	}
+	if got, want := statuses(), map[string]string{alive.URL: HealthUnknown, dead.URL: HealthUnknown}; !reflect.DeepEqual(got, want) {
+		t.Errorf("before the probes: %v, want %v", got, want)
+	}
+
+	dead.Close()
+	s.probeAll()
+	since := c.now
+	if got, want := statuses(), map[string]string{alive.URL: HealthHealthy, dead.URL: HealthUnhealthy}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after the first probe: %v, want %v", got, want)
+	}
+	for _, h := range c.health() {
+		switch h.Address {
+		case alive.URL:
+			if h.LastSeen == nil || !h.LastSeen.Equal(since) || h.UnhealthySince != nil {
+				t.Errorf("alive: %+v", h)
+			}
+		case dead.URL:
+			if h.UnhealthySince == nil || !h.UnhealthySince.Equal(since) || h.Error == "" {
+				t.Errorf("dead: %+v", h)
+			}
+		}
+	}
+
+	// the dead service is kept until it is unhealthy for EvictAfter
+	c.advance(s.EvictAfter - time.Second)
+	s.probeAll()
+	c.heartbeat("dead", dead.URL, http.StatusOK)
+	c.advance(time.Second)
+	s.probeAll()
+	if got, want := c.services(), map[string][]string{"alive": {alive.URL}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after the eviction: %v, want %v", got, want)
+	}
+	// the agent registers again once it finds itself evicted by the heartbeat
+	c.heartbeat("dead", dead.URL, http.StatusNotFound)
+	c.heartbeat("alive", alive.URL, http.StatusOK)
+}
+
+func TestProbeNeverEvicts(t *testing.T) {
+	s := NewMemoryBasedServer()
+	c := newTestCenter(t, s)
+	dead := newFakeAgent(t, "mode: count\n")
+	c.register("dead", dead.URL)
+	dead.Close()
+
+	s.probeAll()
+	c.advance(24 * time.Hour)
+	s.probeAll()
+	if got := c.health(); len(got) != 1 || got[0].Status != HealthUnhealthy {
+		t.Errorf("without EvictAfter: %+v, want the unhealthy service kept", got)
+	}
+}
+
+func TestProbeRecovers(t *testing.T) {
+	s := NewMemoryBasedServer()
+	s.EvictAfter = time.Minute
+	c := newTestCenter(t, s)
+	agent := newFakeAgent(t, "mode: count\n")
+	c.register("svc", agent.URL)
+
+	// an agent failing for less than EvictAfter gets healthy again on the next successful probe
+	s.health.update(agent.URL, c.now, http.ErrServerClosed)
+	c.advance(s.EvictAfter / 2)
+	s.probeAll()
+	got := c.health()
+	if len(got) != 1 || got[0].Status != HealthHealthy || got[0].UnhealthySince != nil || got[0].Error != "" {
+		t.Errorf("after the recovery: %+v", got)
+	}
+	c.advance(s.EvictAfter)
+	s.probeAll()
+	c.heartbeat("svc", agent.URL, http.StatusOK)
+}
@@ -1,10 +1,16 @@
 package cover
 
 import (
+	"bytes"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"os"
 	"path"
-	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
 )
 
@@ -53,13 +59,22 @@ import (
 	_log "log"
 	"net"
 	"net/http"
+	{{if .PushInterval}}
+	"net/url"
+	{{end}}
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
-	"sync/atomic"
 	"syscall"
+	"time"
+	{{if .Native}}
+	"runtime/coverage"
+	"sync"
+	{{else}}
+	"sync/atomic"
 	"testing"
+	{{end}}
 
 	_cover {{.GlobalCoverVarImportPath | printf "%q"}}
 
@@ -69,6 +84,52 @@ func init() {
 	go registerHandlersGoc()
 }
 
+// manifestGoc describes the files instrumented into the program
+const manifestGoc = {{printf "%q" .Manifest}}
+
+{{if .Native}}
+// metaGoc is the coverage meta-data of the program, it never changes and is decoded only once
+var metaGoc struct {
+	once sync.Once
+	meta *_cover.Meta
+	err  error
+}
+
+// loadBlocksGoc converts the current counters of runtime/coverage to the blocks of the profile
+func loadBlocksGoc() (*_cover.Meta, []_cover.Block, error) {
+	metaGoc.once.Do(func() {
+		var buf bytes.Buffer
+		if metaGoc.err = coverage.WriteMeta(&buf); metaGoc.err == nil {
+			metaGoc.meta, metaGoc.err = _cover.ParseMeta(buf.Bytes())
+		}
+		if metaGoc.err != nil {
+			// the toolchain writes a format this build does not know, no profile can be served at all
+			_log.Printf("[goc][ERROR] decode the coverage meta-data failed, err: %v, convert the data of GOCOVERDIR with go tool covdata textfmt instead", metaGoc.err)
+		}
+	})
+	if metaGoc.err != nil {
+		return nil, nil, metaGoc.err
+	}
+	var buf bytes.Buffer
+	if err := coverage.WriteCounters(&buf); err != nil {
+		return nil, nil, err
+	}
+	blocks, err := metaGoc.meta.Blocks(buf.Bytes(), func(file string) bool {
+		// this file is instrumented by the toolchain too, but it is not part of the program
+		return strings.HasSuffix(file, "/http_cover_apis_auto_generated.go")
+	})
+	return metaGoc.meta, blocks, err
+}
+
+// writeProfileGoc writes the counters of runtime/coverage as a coverage profile
+func writeProfileGoc(w io.Writer) error {
+	meta, blocks, err := loadBlocksGoc()
+	if err != nil {
+		return err
+	}
+	return meta.WriteProfile(w, blocks)
+}
+{{else}}
 func loadValuesGoc() (map[string][]uint32, map[string][]testing.CoverBlock) {
 	var (
 		coverCounters = make(map[string][]uint32)
@@ -77,13 +138,21 @@ func loadValuesGoc() (map[string][]uint32, map[string][]testing.CoverBlock) {
 
 	{{range $i, $pkgCover := .DepsCover}}
 	{{range $file, $cover := $pkgCover.Vars}}
+	{{if eq $.Mode "func"}}
+	loadFuncCoverGoc(coverCounters, coverBlocks, {{printf "%q" $cover.File}}, _cover.{{$cover.Var}}.Count[:], _cover.{{$cover.Var}}.Pos[:], _cover.{{$cover.Var}}.NumStmt[:], _cover.{{$cover.Var}}.Func[:])
+	{{else}}
 	loadFileCoverGoc(coverCounters, coverBlocks, {{printf "%q" $cover.File}}, _cover.{{$cover.Var}}.Count[:], _cover.{{$cover.Var}}.Pos[:], _cover.{{$cover.Var}}.NumStmt[:])
 	{{end}}
 	{{end}}
+	{{end}}
 
 	{{range $file, $cover := .MainPkgCover.Vars}}
+	{{if eq $.Mode "func"}}
+	loadFuncCoverGoc(coverCounters, coverBlocks, {{printf "%q" $cover.File}}, _cover.{{$cover.Var}}.Count[:], _cover.{{$cover.Var}}.Pos[:], _cover.{{$cover.Var}}.NumStmt[:], _cover.{{$cover.Var}}.Func[:])
+	{{else}}
 	loadFileCoverGoc(coverCounters, coverBlocks, {{printf "%q" $cover.File}}, _cover.{{$cover.Var}}.Count[:], _cover.{{$cover.Var}}.Pos[:], _cover.{{$cover.Var}}.NumStmt[:])
 	{{end}}
+	{{end}}
 
 	return coverCounters, coverBlocks
 }
@@ -110,16 +179,88 @@ func loadFileCoverGoc(coverCounters map[string][]uint32, coverBlocks map[string]
 	coverBlocks[fileName] = block
 }
 
+{{if eq .Mode "branch"}}
+// loadBranchesGoc loads the outcomes of the branches, keyed by the file and the outcome,
+// such as pkg/file.go:branch.true
+func loadBranchesGoc() (map[string][]uint32, map[string][]testing.CoverBlock) {
+	var (
+		coverCounters = make(map[string][]uint32)
+		coverBlocks   = make(map[string][]testing.CoverBlock)
+	)
+
+	{{range $i, $pkgCover := .DepsCover}}
+	{{range $file, $cover := $pkgCover.Vars}}
+	loadFileCoverGoc(coverCounters, coverBlocks, {{printf "%q" $cover.File}}+":branch.true", _cover.{{$cover.Var}}.BranchTrue[:], _cover.{{$cover.Var}}.BranchTruePos[:], make([]uint16, len(_cover.{{$cover.Var}}.BranchTrue)))
+	loadFileCoverGoc(coverCounters, coverBlocks, {{printf "%q" $cover.File}}+":branch.false", _cover.{{$cover.Var}}.BranchFalse[:], _cover.{{$cover.Var}}.BranchFalsePos[:], make([]uint16, len(_cover.{{$cover.Var}}.BranchFalse)))
+	{{end}}
+	{{end}}
+
+	{{range $file, $cover := .MainPkgCover.Vars}}
+	loadFileCoverGoc(coverCounters, coverBlocks, {{printf "%q" $cover.File}}+":branch.true", _cover.{{$cover.Var}}.BranchTrue[:], _cover.{{$cover.Var}}.BranchTruePos[:], make([]uint16, len(_cover.{{$cover.Var}}.BranchTrue)))
+	loadFileCoverGoc(coverCounters, coverBlocks, {{printf "%q" $cover.File}}+":branch.false", _cover.{{$cover.Var}}.BranchFalse[:], _cover.{{$cover.Var}}.BranchFalsePos[:], make([]uint16, len(_cover.{{$cover.Var}}.BranchFalse)))
+	{{end}}
+
+	return coverCounters, coverBlocks
+}
+{{end}}
+
+// loadFuncCoverGoc registers every function of the file in func mode as a file of its own,
+// keyed by the file and the function name, such as pkg/file.go:(*T).M
+func loadFuncCoverGoc(coverCounters map[string][]uint32, coverBlocks map[string][]testing.CoverBlock, fileName string, counter []uint32, pos []uint32, numStmts []uint16, funcs []string) {
+	for i := range counter {
+		loadFileCoverGoc(coverCounters, coverBlocks, fileName+":"+funcs[i], counter[i:i+1], pos[3*i:3*i+3], numStmts[i:i+1])
+	}
+}
+
+// writeProfileGoc writes the counters as a coverage profile
+func writeProfileGoc(w io.Writer) error {
+	if _, err := fmt.Fprint(w, "mode: {{.Mode}}\n"); err != nil {
+		return err
+	}
+	counters, blocks := loadValuesGoc()
+	{{if eq .Mode "branch"}}
+	// the outcomes of the branches are reported as files without statements
+	branchCounters, branchBlocks := loadBranchesGoc()
+	for name := range branchCounters {
+		counters[name] = branchCounters[name]
+		blocks[name] = branchBlocks[name]
+	}
+	{{end}}
+	for name, counts := range counters {
+		block := blocks[name]
+		for i := range counts {
+			count := atomic.LoadUint32(&counts[i]) // For -mode=atomic.
+			_, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", name,
+				block[i].Line0, block[i].Col0,
+				block[i].Line1, block[i].Col1,
+				block[i].Stmts,
+				count)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func clearValuesGoc() {
 
 	{{range $i, $pkgCover := .DepsCover}}
 	{{range $file, $cover := $pkgCover.Vars}}
 	clearFileCoverGoc(_cover.{{$cover.Var}}.Count[:])
+	{{if eq $.Mode "branch"}}
+	clearFileCoverGoc(_cover.{{$cover.Var}}.BranchTrue[:])
+	clearFileCoverGoc(_cover.{{$cover.Var}}.BranchFalse[:])
+	{{end}}
 	{{end}}
 	{{end}}
 
 	{{range $file, $cover := .MainPkgCover.Vars}}
 	clearFileCoverGoc(_cover.{{$cover.Var}}.Count[:])
+	{{if eq $.Mode "branch"}}
+	clearFileCoverGoc(_cover.{{$cover.Var}}.BranchTrue[:])
+	clearFileCoverGoc(_cover.{{$cover.Var}}.BranchFalse[:])
+	{{end}}
 	{{end}}
 
 }
@@ -130,8 +271,10 @@ func clearFileCoverGoc(counter []uint32) {
 	}
 }
 
+{{end}}
+
 func registerHandlersGoc() {
-	{{if .Singleton}}
+	{{if or .Singleton .PushInterval}}
 	ln, _, err := listenGoc()
 	{{else}}
 	ln, host, err := listenGoc()
@@ -139,11 +282,10 @@ func registerHandlersGoc() {
 	if err != nil {
 		_log.Fatalf("listenGoc failed, err:%v", err)
 	}
-	{{if not .Singleton}}
+	{{if not (or .Singleton .PushInterval)}}
 	profileAddr := "http://" + host
-	if resp, err := registerSelfGoc(profileAddr); err != nil {
-		_log.Fatalf("register address %v failed, err: %v, response: %v", profileAddr, err, string(resp))
-	}
+	// the service starts even if the center is unreachable, it is registered once the center is up
+	go registerLoopGoc(profileAddr)
 
 	fn := func() {
 		var (
@@ -151,8 +293,10 @@ func registerHandlersGoc() {
 			profileAddrs []string
 			addresses    []string
 		)
+		// the program may keep running to shut down gracefully, it should not be registered again
+		close(deregisteredGoc)
 		if addresses, err = getAllHostsGoc(ln); err != nil {
-			_log.Fatalf("get all host failed, err: %v", err)
+			_log.Printf("[goc][WARN] get all host failed, err: %v", err)
 			return
 		}
 		for _, addr := range addresses {
@@ -161,9 +305,79 @@ func registerHandlersGoc() {
 		deregisterSelfGoc(profileAddrs)
 	}
 	go watchSignalGoc(fn)
+	{{else if .PushInterval}}
+	// the profile is pushed instead of registering the address, which may be unreachable from the center
+	go pushLoopGoc()
+	// the signals are watched to push the last profile
+	go watchSignalGoc(func() {})
+	{{else}}
+	// the signals are only watched to dump the profile
+	if coverDirGoc != "" {
+		go watchSignalGoc(func() {})
+	}
 	{{end}}
 
 	mux := http.NewServeMux()
+	// coverprofile reports a coverage profile with the coverage percentage
+	mux.HandleFunc("/v1/cover/profile", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := writeProfileGoc(&buf); err != nil {
+			http.Error(w, fmt.Sprintf("invalid block format, err: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Write(buf.Bytes())
+	})
+
+	// Flush dumps the profile to GOC_COVER_DIR right now, and reports the name of the file
+	mux.HandleFunc("/v1/cover/flush", func(w http.ResponseWriter, r *http.Request) {
+		name, err := flushCoverGoc()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if name == "" {
+			http.Error(w, "GOC_COVER_DIR is not set", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, name)
+	})
+
+	// Manifest reports the module, the revision and the files the program is instrumented from
+	mux.HandleFunc("/v1/cover/manifest", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, manifestGoc)
+	})
+
+	{{if .Native}}
+	// Coverage reports the current code coverage as a fraction in the range [0, 1].
+	mux.HandleFunc("/v1/cover/coverage", func(w http.ResponseWriter, r *http.Request) {
+		_, blocks, err := loadBlocksGoc()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var n int64
+		for _, block := range blocks {
+			if block.Count > 0 {
+				n++
+			}
+		}
+		if len(blocks) == 0 {
+			fmt.Fprint(w, 0)
+			return
+		}
+		fmt.Fprintf(w, "%f", float64(n)/float64(len(blocks)))
+	})
+
+	mux.HandleFunc("/v1/cover/clear", func(w http.ResponseWriter, r *http.Request) {
+		if err := coverage.ClearCounters(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "clear call successfully")
+	})
+	{{else}}
 	// Coverage reports the current code coverage as a fraction in the range [0, 1].
 	// If coverage is not enabled, Coverage returns 0.
 	mux.HandleFunc("/v1/cover/coverage", func(w http.ResponseWriter, r *http.Request) {
@@ -184,62 +398,218 @@ func registerHandlersGoc() {
 		fmt.Fprintf(w, "%f", float64(n)/float64(d))
 	})
 
-	// coverprofile reports a coverage profile with the coverage percentage
-	mux.HandleFunc("/v1/cover/profile", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, "mode: {{.Mode}}\n")
-		counters, blocks := loadValuesGoc()
-		var active, total int64
-		var count uint32
-		for name, counts := range counters {
-			block := blocks[name]
-			for i := range counts {
-				stmts := int64(block[i].Stmts)
-				total += stmts
-				count = atomic.LoadUint32(&counts[i]) // For -mode=atomic.
-				if count > 0 {
-					active += stmts
-				}
-				_, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", name,
-					block[i].Line0, block[i].Col0,
-					block[i].Line1, block[i].Col1,
-					stmts,
-					count)
-				if err != nil {
-					fmt.Fprintf(w, "invalid block format, err: %v", err)
-					return
-				}
-			}
-		}
-	})
-
 	mux.HandleFunc("/v1/cover/clear", func(w http.ResponseWriter, r *http.Request) {
 		clearValuesGoc()
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, "clear call successfully")
 	})
+	{{end}}
 
 	_log.Fatal(http.Serve(ln, mux))
 }
 
-func registerSelfGoc(address string) ([]byte, error) {
-	customServiceName, ok := os.LookupEnv("GOC_SERVICE_NAME")
-	var selfName string
-	if ok {
-		selfName = customServiceName
-	} else {
-		selfName = filepath.Base(os.Args[0])
+// coverDirGoc is the directory the profile is dumped to on exit and on flush, nothing is dumped if empty
+var coverDirGoc = os.Getenv("GOC_COVER_DIR")
+
+// coverFileGoc names the dump of this process, every flush replaces it as the counters only grow
+var coverFileGoc = fmt.Sprintf("goc.%s.%d.%d.cov", filepath.Base(os.Args[0]), os.Getpid(), time.Now().UnixNano())
+
+// flushCoverGoc dumps the profile to coverDirGoc, and returns the name of the file
+func flushCoverGoc() (string, error) {
+	if coverDirGoc == "" {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := writeProfileGoc(&buf); err != nil {
+		return "", err
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/cover/register?name=%s&address=%s", {{.Center | printf "%q"}}, selfName, address), nil)
+	if err := os.MkdirAll(coverDirGoc, 0755); err != nil {
+		return "", err
+	}
+	name := filepath.Join(coverDirGoc, coverFileGoc)
+	// write to a temporary file first, so a merge never reads a partial profile
+	f, err := ioutil.TempFile(coverDirGoc, coverFileGoc+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return name, os.Rename(f.Name(), name)
+}
+
+// exitFlushGoc is deferred by the main function to dump the profile when the program exits normally
+func exitFlushGoc() {
+	if _, err := flushCoverGoc(); err != nil {
+		_log.Printf("[goc][WARN] fail to dump the profile to %s, err: %v", coverDirGoc, err)
+	}
+	{{if .PushInterval}}
+	if err := pushProfileGoc(); err != nil {
+		_log.Printf("[goc][WARN] fail to push the last profile, err: %v", err)
+	}
+	{{end}}
+}
+
+// exitGoc replaces the os.Exit calls of the main package, which skip the deferred exitFlushGoc
+func exitGoc(code int) {
+	exitFlushGoc()
+	os.Exit(code)
+}
+
+// fatalGoc, fatalfGoc and fatallnGoc replace the log.Fatal calls of the main package, they log like them
+func fatalGoc(v ...interface{}) {
+	_log.Output(2, fmt.Sprint(v...))
+	exitGoc(1)
+}
+
+func fatalfGoc(format string, v ...interface{}) {
+	_log.Output(2, fmt.Sprintf(format, v...))
+	exitGoc(1)
+}
+
+func fatallnGoc(v ...interface{}) {
+	_log.Output(2, fmt.Sprintln(v...))
+	exitGoc(1)
+}
+
+{{if .PushInterval}}
+// pushInstanceGoc identifies this process among the instances pushing to the center
+var pushInstanceGoc = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d/%d", hostname, os.Getpid(), time.Now().UnixNano())
+}()
+
+// pushLoopGoc pushes the profile to the center on every interval
+func pushLoopGoc() {
+	interval, err := time.ParseDuration({{printf "%q" .PushInterval}})
+	if err != nil {
+		_log.Printf("[goc][WARN] invalid push interval, err: %v", err)
+		return
+	}
+	for range time.Tick(interval) {
+		if err := pushProfileGoc(); err != nil {
+			_log.Printf("[goc][WARN] push profile failed, err: %v", err)
+		}
+	}
+}
+
+// pushProfileGoc pushes the profile to the center, which keeps the latest one of each instance
+func pushProfileGoc() error {
+	var buf bytes.Buffer
+	if err := writeProfileGoc(&buf); err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/v1/cover/push?name=%s&instance=%s&interval=%s", {{.Center | printf "%q"}}, url.QueryEscape(serviceNameGoc()), url.QueryEscape(pushInstanceGoc), {{printf "%q" .PushInterval}})
+	resp, err := http.Post(u, "text/plain", &buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("response code %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+{{end}}
+
+// serviceNameGoc is the name the service is registered as
+func serviceNameGoc() string {
+	if name, ok := os.LookupEnv("GOC_SERVICE_NAME"); ok {
+		return name
+	}
+	return filepath.Base(os.Args[0])
+}
+
+const (
+	registerMinBackoffGoc = time.Second
+	registerMaxBackoffGoc = time.Minute
+)
+
+// deregisteredGoc is closed when the address is deregistered on the termination signal
+var deregisteredGoc = make(chan struct{})
+
+// heartbeatIntervalGoc is the interval of the heartbeats, GOC_HEARTBEAT_INTERVAL such as 30s overrides it
+var heartbeatIntervalGoc = func() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("GOC_HEARTBEAT_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return 10 * time.Second
+}()
+
+// registerLoopGoc registers the address with exponential backoff while the center is unreachable,
+// then sends heartbeats and registers again once the center no longer knows the address, such as after a restart
+func registerLoopGoc(address string) {
+	backoff := registerMinBackoffGoc
+	registered := false
+	for {
+		if registered {
+			select {
+			case <-deregisteredGoc:
+				return
+			case <-time.After(heartbeatIntervalGoc):
+			}
+			known, err := heartbeatSelfGoc(address)
+			if err != nil {
+				_log.Printf("[goc][WARN] heartbeat of address %v failed, err: %v", address, err)
+				continue
+			}
+			if known {
+				continue
+			}
+			_log.Printf("[goc][WARN] address %v is unknown to the coverage center, register again", address)
+			registered = false
+		}
+
+		if resp, err := registerSelfGoc(address); err != nil {
+			_log.Printf("[goc][WARN] register address %v failed, retry in %v, err: %v, response: %v", address, backoff, err, string(resp))
+			select {
+			case <-deregisteredGoc:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > registerMaxBackoffGoc {
+				backoff = registerMaxBackoffGoc
+			}
+			continue
+		}
+		registered = true
+		backoff = registerMinBackoffGoc
+	}
+}
+
+// heartbeatSelfGoc reports whether the address is still registered in the center
+func heartbeatSelfGoc(address string) (bool, error) {
+	resp, err := http.Post(fmt.Sprintf("%s/v1/cover/heartbeat?name=%s&address=%s", {{.Center | printf "%q"}}, serviceNameGoc(), address), "", nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return false, fmt.Errorf("response code %d: %s", resp.StatusCode, body)
+	}
+}
+
+func registerSelfGoc(address string) ([]byte, error) {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/cover/register?name=%s&address=%s", {{.Center | printf "%q"}}, serviceNameGoc(), address), nil)
 	if err != nil {
-		_log.Fatalf("http.NewRequest failed: %v", err)
 		return nil, err
 	}
 
 	resp, err := http.DefaultClient.Do(req)
-	if err != nil && isNetworkErrorGoc(err) {
-		_log.Printf("[goc][WARN]error occurred:%v, try again", err)
-		resp, err = http.DefaultClient.Do(req)
-	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to register into coverage center, err:%v", err)
 	}
@@ -296,21 +666,23 @@ func deregisterSelfGoc(address []string) ([]byte, error) {
 
 type CallbackGocFunc func()
 
+// watchSignalGoc calls fn and dumps the profile on the first termination signal, then stops watching
+// and raises the signal again. Without another handler the default action ends the program the way it does
+// without the agent, the handlers of the program get the signal too and decide how it ends.
 func watchSignalGoc(fn CallbackGocFunc) {
-	// init signal
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
-	for {
-		si := <-c
-		_log.Printf("get a signal %s", si.String())
-		switch si {
-		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
-			fn()
-			os.Exit(0) // Exit successfully.
-		case syscall.SIGHUP:
-		default:
-			return
-		}
+	signal.Notify(c, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	si := <-c
+	_log.Printf("get a signal %s", si.String())
+	fn()
+	exitFlushGoc()
+	signal.Stop(c)
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(si)
+	}
+	if err != nil {
+		_log.Printf("[goc][WARN] fail to raise the signal %s again, err: %v", si.String(), err)
 	}
 }
 
@@ -497,14 +869,14 @@ func checkCacheDir(p string) error {
 	return nil
 }
 
-func injectGlobalCoverVarFile(ci *CoverInfo, content string) error {
-	coverFile, err := os.Create(filepath.Join(ci.Target, ci.GlobalCoverVarImportPath, "cover.go"))
+func injectGlobalCoverVarFile(dest string, pkgName string, content string) error {
+	coverFile, err := os.Create(dest)
 	if err != nil {
 		return err
 	}
 	defer coverFile.Close()
 
-	packageName := "package " + filepath.Base(ci.GlobalCoverVarImportPath) + "\n\n"
+	packageName := "package " + pkgName + "\n\n"
 
 	_, err = coverFile.WriteString(packageName)
 	if err != nil {
@@ -514,3 +886,127 @@ func injectGlobalCoverVarFile(ci *CoverInfo, content string) error {
 
 	return err
 }
+
+// exitRewrites routes the exits of the main package through the functions dumping the profile first,
+// keyed by the import path and the selector of the exit
+var exitRewrites = map[string]map[string]string{
+	"os":  {"Exit": "exitGoc"},
+	"log": {"Fatal": "fatalGoc", "Fatalf": "fatalfGoc", "Fatalln": "fatallnGoc"},
+}
+
+// injectExitFlush makes the main function of the package dump the profile when it returns,
+// and the os.Exit and log.Fatal calls of the package dump it before exiting, as they skip the deferred calls.
+// The files are looked up in their current content, annotated or not.
+func injectExitFlush(pkg *Package, overlay *Overlay) error {
+	for _, file := range pkg.GoFiles {
+		name := path.Join(pkg.Dir, file)
+		src := overlay.Current(name)
+		content, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		injected, ok, err := rewriteExits(src, content)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		dest, err := overlay.Path(name)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(dest, injected, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteExits defers exitFlushGoc in the main function and replaces the exits in exitRewrites of the file,
+// false if there is nothing to rewrite
+func rewriteExits(name string, content []byte) ([]byte, bool, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, content, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, false, err
+	}
+	// the local names of the imports to rewrite
+	imports := make(map[string]map[string]string)
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, false, err
+		}
+		rewrites, ok := exitRewrites[importPath]
+		if !ok {
+			continue
+		}
+		local := path.Base(importPath)
+		if spec.Name != nil {
+			local = spec.Name.Name
+		}
+		if local != "_" && local != "." {
+			imports[local] = rewrites
+		}
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	// the imports still need a use after their exits are replaced
+	used := make(map[string]string)
+	// the offsets are not affected by the //line directive of the annotated files
+	offset := func(pos token.Pos) int { return fset.File(pos).Offset(pos) }
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if n.Recv == nil && n.Name.Name == "main" && n.Body != nil {
+				at := offset(n.Body.Lbrace) + 1
+				edits = append(edits, edit{at, at, "defer exitFlushGoc();"})
+			}
+		case *ast.SelectorExpr:
+			x, ok := n.X.(*ast.Ident)
+			if !ok {
+				break
+			}
+			if fn, ok := imports[x.Name][n.Sel.Name]; ok {
+				start, end := offset(n.Pos()), offset(n.End())
+				// keep the columns of the rest of the line, the native backend instruments the rewritten file
+				if pad := end - start - len(fn); pad >= 0 {
+					fn += strings.Repeat(" ", pad)
+				} else {
+					pos := fset.Position(n.End())
+					fn += fmt.Sprintf("/*line :%d:%d*/", pos.Line, pos.Column)
+				}
+				edits = append(edits, edit{start, end, fn})
+				used[x.Name] = n.Sel.Name
+			}
+		}
+		return true
+	})
+	if len(edits) == 0 {
+		return nil, false, nil
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var buf bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		buf.Write(content[last:e.start])
+		buf.WriteString(e.text)
+		last = e.end
+	}
+	buf.Write(content[last:])
+	locals := make([]string, 0, len(used))
+	for local := range used {
+		locals = append(locals, local)
+	}
+	sort.Strings(locals)
+	for _, local := range locals {
+		fmt.Fprintf(&buf, "\nvar _ = %s.%s\n", local, used[local])
+	}
+	return buf.Bytes(), true, nil
+}
@@ -0,0 +1,128 @@
+package cover
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestRewriteExits(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string // the substrings of the rewritten file
+		notWant []string
+	}{
+		{
+			name: "exit",
+			src: `package main
+
+import "os"
+
+func main() {
+	if err := run(); err != nil {
+		os.Exit(1)
+	}
+}
+`,
+			want:    []string{"func main() {defer exitFlushGoc();", "exitGoc(1)", "var _ = os.Exit"},
+			notWant: []string{"os.Exit(1)"},
+		},
+		{
+			name: "fatal",
+			src: `package main
+
+import "log"
+
+func run() {
+	log.Fatal("a")
+	log.Fatalf("%s", "b")
+	log.Fatalln("c")
+	log.Println("d")
+}
+`,
+			want:    []string{"fatalGoc (\"a\")", "fatalfGoc (\"%s\", \"b\")", "fatallnGoc (\"c\")", "log.Println", "var _ = log.Fatal"},
+			notWant: []string{"defer exitFlushGoc"},
+		},
+		{
+			name: "alias",
+			src: `package main
+
+import (
+	o "os"
+	stdlog "log"
+)
+
+var exit, code = o.Exit, 2
+
+func main() { stdlog.Fatal(o.Getpid()) }
+`,
+			want: []string{"var exit, code = exitGoc/*line", "fatalGoc    (o.Getpid())", "var _ = o.Exit", "var _ = stdlog.Fatal"},
+		},
+		{
+			name: "other packages",
+			src: `package main
+
+import (
+	_ "os"
+	log "github.com/sirupsen/logrus"
+)
+
+func run() { log.Fatal("a") }
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := rewriteExits("main.go", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != (len(tt.want) != 0) {
+				t.Fatalf("rewritten %v, want %v", ok, len(tt.want) != 0)
+			}
+			if !ok {
+				return
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(string(got), s) {
+					t.Errorf("%q not found in:\n%s", s, got)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(string(got), s) {
+					t.Errorf("%q found in:\n%s", s, got)
+				}
+			}
+			checkPositions(t, tt.src, string(got))
+		})
+	}
+}
+
+// checkPositions checks the identifiers after the exits keep their positions, except the rewritten ones
+func checkPositions(t *testing.T, src, rewritten string) {
+	t.Helper()
+	positions := func(src string) map[string]token.Position {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "main.go", src, 0)
+		if err != nil {
+			t.Fatalf("invalid rewritten file: %v\n%s", err, src)
+		}
+		res := make(map[string]token.Position)
+		ast.Inspect(f, func(n ast.Node) bool {
+			if lit, ok := n.(*ast.BasicLit); ok {
+				res[lit.Value] = fset.Position(lit.Pos())
+			}
+			return true
+		})
+		return res
+	}
+	want, got := positions(src), positions(rewritten)
+	for lit, pos := range want {
+		if p := got[lit]; p.Line != pos.Line || p.Column != pos.Column {
+			t.Errorf("%s moved from %d:%d to %d:%d", lit, pos.Line, pos.Column, p.Line, p.Column)
+		}
+	}
+}
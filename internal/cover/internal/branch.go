@@ -0,0 +1,106 @@
+package tool
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+)
+
+// branchMode counts the basic blocks as the count mode does, and the outcomes of the branches too:
+// each operand of && and ||, and each if condition gets a counter for true and one for false,
+// each switch case gets a counter for true when it is taken, and a switch without default
+// gets a counter for false when no case is taken.
+const branchMode = "branch"
+
+// BranchFuncName is the function counting the outcomes of a condition,
+// it is declared in the global cover var package by BranchFuncDecl
+const BranchFuncName = "GoCoverBranch"
+
+// BranchFuncDecl is the declaration of BranchFuncName
+const BranchFuncDecl = `
+// ` + BranchFuncName + ` counts the outcome of the condition c and returns it
+func ` + BranchFuncName + `[B ~bool](t, f *uint32, c B) B {
+	if c {
+		*t++
+	} else {
+		*f++
+	}
+	return c
+}
+`
+
+// addCondBranch wraps the condition e to count both of its outcomes
+func (f *File) addCondBranch(e ast.Expr) {
+	t, fl := len(f.trueBranches), len(f.falseBranches)
+	f.trueBranches = append(f.trueBranches, Block{e.Pos(), e.End(), 0})
+	f.falseBranches = append(f.falseBranches, Block{e.Pos(), e.End(), 0})
+	f.edit.Insert(f.offset(e.Pos()),
+		fmt.Sprintf("%s(&%s.BranchTrue[%d], &%s.BranchFalse[%d], ", BranchFuncName, f.varVar, t, f.varVar, fl))
+	ast.Walk(f, e)
+	f.edit.Insert(f.offset(e.End()), ")")
+}
+
+// addOperandBranches counts the outcomes of the operands of a chain of && and ||
+func (f *File) addOperandBranches(e *ast.BinaryExpr) {
+	for _, operand := range shortCircuitOperands(e) {
+		f.addCondBranch(operand)
+	}
+}
+
+// shortCircuitOperands returns the operands of the && and || chain, such as a, b and c of (a || b) && c
+func shortCircuitOperands(e ast.Expr) []ast.Expr {
+	inner := ast.Unparen(e)
+	if b, ok := inner.(*ast.BinaryExpr); ok && (b.Op == token.LAND || b.Op == token.LOR) {
+		return append(shortCircuitOperands(b.X), shortCircuitOperands(b.Y)...)
+	}
+	return []ast.Expr{e}
+}
+
+// addSwitchBranches counts the cases taken by the switch, and the switch taking no case if it has no default.
+// The children of the switch are walked first, so that the added default clause follows their counters.
+func (f *File) addSwitchBranches(switchPos token.Pos, body *ast.BlockStmt, children ...ast.Node) {
+	hasDefault := false
+	for _, stmt := range body.List {
+		clause, ok := stmt.(*ast.CaseClause)
+		if !ok {
+			continue
+		}
+		if clause.List == nil {
+			hasDefault = true
+		}
+		if f.ignored(clause) {
+			continue
+		}
+		f.edit.Insert(f.offset(clause.Colon+1), fmt.Sprintf("%s.BranchTrue[%d]++;", f.varVar, len(f.trueBranches)))
+		f.trueBranches = append(f.trueBranches, Block{clause.Case, clause.Colon + 1, 0})
+	}
+	for _, child := range children {
+		if child != nil {
+			ast.Walk(f, child)
+		}
+	}
+	ast.Walk(f, body)
+	if hasDefault {
+		return
+	}
+	f.edit.Insert(f.offset(body.Rbrace), fmt.Sprintf("default: %s.BranchFalse[%d]++;", f.varVar, len(f.falseBranches)))
+	f.falseBranches = append(f.falseBranches, Block{switchPos, body.Lbrace, 0})
+}
+
+// addBranchVariables declares the counters and the positions of the branches,
+// encoded as the basic blocks are
+func (f *File) addBranchVariables(w io.Writer) {
+	for _, branches := range []struct {
+		name   string
+		blocks []Block
+	}{{"BranchTrue", f.trueBranches}, {"BranchFalse", f.falseBranches}} {
+		fmt.Fprintf(w, "\t%sPos: [3 * %d]uint32{\n", branches.name, len(branches.blocks))
+		for i, block := range branches.blocks {
+			start := f.fset.Position(block.startByte)
+			end := f.fset.Position(block.endByte)
+			fmt.Fprintf(w, "\t\t%d, %d, %#x, // [%d]\n", start.Line, end.Line, (end.Column&0xFFFF)<<16|(start.Column&0xFFFF), i)
+		}
+		fmt.Fprintf(w, "\t},\n")
+	}
+}
@@ -60,8 +60,6 @@ import (
 
 // var profile string // The profile to read; the value of -html or -func
 
-var counterStmt func(*File, string) string
-
 const (
 	atomicPackagePath = "sync/atomic"
 	atomicPackageName = "_cover_atomic_"
@@ -165,6 +163,15 @@ type File struct {
 	edit    *Buffer // QINIU
 	varVar  string  // QINIU
 	mode    string  // QINIU
+
+	counterStmt func(*File, string) string // QINIU, per file so that files can be annotated concurrently
+	seenPos2    map[pos2]bool              // QINIU, per file so that files can be annotated concurrently
+	ignoreLines map[int]bool               // lines of the //scope:ignore directives, true if the directive is alone on its line
+	funcNames   []string                   // names of the functions of the blocks in func mode
+	seenFuncs   map[string]int             // times of the function names seen in func mode
+
+	trueBranches  []Block // positions of the true outcomes in branch mode
+	falseBranches []Block // positions of the false outcomes in branch mode
 }
 
 // findText finds text in the original source, starting at pos.
@@ -205,7 +212,27 @@ func (f *File) findText(pos token.Pos, text string) int {
 
 // Visit implements the ast.Visitor interface.
 func (f *File) Visit(node ast.Node) ast.Visitor {
+	switch node.(type) {
+	case *ast.FuncDecl, *ast.FuncLit, *ast.BlockStmt, *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt,
+		*ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt, *ast.CaseClause, *ast.CommClause:
+		if f.ignored(node) {
+			return nil
+		}
+	}
+	if f.mode == funcMode {
+		return f.visitFunc(node)
+	}
 	switch n := node.(type) {
+	case *ast.GenDecl:
+		// Constant expressions can not call the branch counting function.
+		if f.mode == branchMode && n.Tok == token.CONST {
+			return nil
+		}
+	case *ast.BinaryExpr:
+		if f.mode == branchMode && (n.Op == token.LAND || n.Op == token.LOR) {
+			f.addOperandBranches(n)
+			return nil
+		}
 	case *ast.BlockStmt:
 		// If it's a switch or select, the body is a list of case clauses; don't tag the block itself.
 		if len(n.List) > 0 {
@@ -213,12 +240,18 @@ func (f *File) Visit(node ast.Node) ast.Visitor {
 			case *ast.CaseClause: // switch
 				for _, n := range n.List {
 					clause := n.(*ast.CaseClause)
+					if f.ignored(clause) {
+						continue
+					}
 					f.addCounters(clause.Colon+1, clause.Colon+1, clause.End(), clause.Body, false)
 				}
 				return f
 			case *ast.CommClause: // select
 				for _, n := range n.List {
 					clause := n.(*ast.CommClause)
+					if f.ignored(clause) {
+						continue
+					}
 					f.addCounters(clause.Colon+1, clause.Colon+1, clause.End(), clause.Body, false)
 				}
 				return f
@@ -229,7 +262,11 @@ func (f *File) Visit(node ast.Node) ast.Visitor {
 		if n.Init != nil {
 			ast.Walk(f, n.Init)
 		}
-		ast.Walk(f, n.Cond)
+		if f.mode == branchMode {
+			f.addCondBranch(n.Cond)
+		} else {
+			ast.Walk(f, n.Cond)
+		}
 		ast.Walk(f, n.Body)
 		if n.Else == nil {
 			return nil
@@ -288,6 +325,10 @@ func (f *File) Visit(node ast.Node) ast.Visitor {
 			}
 			return nil
 		}
+		if f.mode == branchMode {
+			f.addSwitchBranches(n.Switch, n.Body, n.Init, n.Tag)
+			return nil
+		}
 	case *ast.TypeSwitchStmt:
 		// Don't annotate an empty type switch - creates a syntax error.
 		if n.Body == nil || len(n.Body.List) == 0 {
@@ -297,17 +338,24 @@ func (f *File) Visit(node ast.Node) ast.Visitor {
 			ast.Walk(f, n.Assign)
 			return nil
 		}
+		if f.mode == branchMode {
+			f.addSwitchBranches(n.Switch, n.Body, n.Init, n.Assign)
+			return nil
+		}
 	}
 	return f
 }
 
 // QINIU
 // Annotate do following
-// 1. add cover variables into the original file
-// 2. return the cover variables declarations as plain string
+// 1. add cover variables into the original file, and write it to dest
+// 2. return the cover variables declarations as plain string,
+// which is empty if the file is generated or ignored and written to dest unchanged
+// It is safe to annotate different files concurrently.
 // original dec: func annotate(name string) {
-func Annotate(name string, mode string, varVar string, globalCoverVarImportPath string) string {
+func Annotate(name string, dest string, mode string, varVar string, globalCoverVarImportPath string) (decl string, err error) {
 	// QINIU
+	var counterStmt func(*File, string) string
 	switch mode {
 	case "set":
 		counterStmt = setCounterStmt
@@ -319,14 +367,21 @@ func Annotate(name string, mode string, varVar string, globalCoverVarImportPath
 		counterStmt = incCounterStmt
 	}
 
+	// the walker panics on the source it does not understand, report it as an error of this file
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cover: %s: internal error: %v", name, r)
+		}
+	}()
+
 	fset := token.NewFileSet()
 	content, err := ioutil.ReadFile(name)
 	if err != nil {
-		log.Fatalf("cover: %s: %s", name, err)
+		return "", fmt.Errorf("cover: %s: %w", name, err)
 	}
 	parsedFile, err := parser.ParseFile(fset, name, content, parser.ParseComments)
 	if err != nil {
-		log.Fatalf("cover: %s: %s", name, err)
+		return "", fmt.Errorf("cover: %s: %w", name, err)
 	}
 
 	file := &File{
@@ -337,8 +392,20 @@ func Annotate(name string, mode string, varVar string, globalCoverVarImportPath
 		astFile: parsedFile,
 		varVar:  varVar,
 		mode:    mode,
+
+		counterStmt: counterStmt,
+		seenPos2:    make(map[pos2]bool),
 	}
 
+	if skipFile(parsedFile) {
+		log.Info("generated or ignored file, skip: ", name)
+		if err := writeAnnotated(dest, name, content); err != nil {
+			return "", fmt.Errorf("cover: %s: %w", name, err)
+		}
+		return "", nil
+	}
+
+	file.collectIgnoreLines()
 	ast.Walk(file, file.astFile)
 	newContent := file.edit.Bytes()
 
@@ -375,16 +442,8 @@ func Annotate(name string, mode string, varVar string, globalCoverVarImportPath
 	// 		log.Fatalf("cover: %s", err)
 	// 	}
 	// }
-	fd, err := os.Create(name)
-	if err != nil {
-		log.Fatalf("cover: %s", err)
-	}
-	defer fd.Close()
-
-	fmt.Fprintf(fd, "//line %s:1\n", name)
-	_, err = fd.Write(newContent)
-	if err != nil {
-		log.Fatalf("cover: %s", err)
+	if err := writeAnnotated(dest, name, newContent); err != nil {
+		return "", fmt.Errorf("cover: %s: %w", name, err)
 	}
 
 	// After printing the source tree, add some declarations for the counters etc.
@@ -395,7 +454,20 @@ func Annotate(name string, mode string, varVar string, globalCoverVarImportPath
 	// we will write all declarations into a single file
 	declBuf := bytes.NewBufferString("")
 	file.addVariables(declBuf)
-	return declBuf.String()
+	return declBuf.String(), nil
+}
+
+// writeAnnotated writes the content to dest, keeping the positions of the source file name
+func writeAnnotated(dest, name string, content []byte) error {
+	fd, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	fmt.Fprintf(fd, "//line %s:1\n", name)
+	_, err = fd.Write(content)
+	return err
 }
 
 // setCounterStmt returns the expression: __count[23] = 1.
@@ -416,7 +488,7 @@ func atomicCounterStmt(f *File, counter string) string {
 // QINIU
 // newCounter creates a new counter expression of the appropriate form.
 func (f *File) newCounter(start, end token.Pos, numStmt int) string {
-	stmt := counterStmt(f, fmt.Sprintf("%s.Count[%d]", f.varVar, len(f.blocks)))
+	stmt := f.counterStmt(f, fmt.Sprintf("%s.Count[%d]", f.varVar, len(f.blocks)))
 	f.blocks = append(f.blocks, Block{start, end, numStmt})
 	return stmt
 }
@@ -693,6 +765,15 @@ func (f *File) addVariables(w io.Writer) {
 	fmt.Fprintf(w, "\tCount     [%d]uint32\n", len(f.blocks))
 	fmt.Fprintf(w, "\tPos       [3 * %d]uint32\n", len(f.blocks))
 	fmt.Fprintf(w, "\tNumStmt   [%d]uint16\n", len(f.blocks))
+	if f.mode == funcMode {
+		fmt.Fprintf(w, "\tFunc      [%d]string\n", len(f.blocks))
+	}
+	if f.mode == branchMode {
+		fmt.Fprintf(w, "\tBranchTrue     [%d]uint32\n", len(f.trueBranches))
+		fmt.Fprintf(w, "\tBranchTruePos  [3 * %d]uint32\n", len(f.trueBranches))
+		fmt.Fprintf(w, "\tBranchFalse    [%d]uint32\n", len(f.falseBranches))
+		fmt.Fprintf(w, "\tBranchFalsePos [3 * %d]uint32\n", len(f.falseBranches))
+	}
 	fmt.Fprintf(w, "} {\n")
 
 	// Initialize the position array field.
@@ -706,7 +787,7 @@ func (f *File) addVariables(w io.Writer) {
 		start := f.fset.Position(block.startByte)
 		end := f.fset.Position(block.endByte)
 
-		start, end = dedup(start, end)
+		start, end = f.dedup(start, end)
 
 		fmt.Fprintf(w, "\t\t%d, %d, %#x, // [%d]\n", start.Line, end.Line, (end.Column&0xFFFF)<<16|(start.Column&0xFFFF), i)
 	}
@@ -731,6 +812,20 @@ func (f *File) addVariables(w io.Writer) {
 	// Close the statements-per-block array.
 	fmt.Fprintf(w, "\t},\n")
 
+	// The function names of the blocks in func mode.
+	if f.mode == funcMode {
+		fmt.Fprintf(w, "\tFunc: [%d]string{\n", len(f.blocks))
+		for i, name := range f.funcNames {
+			fmt.Fprintf(w, "\t\t%q, // %d\n", name, i)
+		}
+		fmt.Fprintf(w, "\t},\n")
+	}
+
+	// The positions of the branches in branch mode.
+	if f.mode == branchMode {
+		f.addBranchVariables(w)
+	}
+
 	// Close the struct initialization.
 	fmt.Fprintf(w, "}\n")
 
@@ -753,13 +848,11 @@ type pos2 struct {
 	p1, p2 token.Position
 }
 
-// seenPos2 tracks whether we have seen a token.Position pair.
-var seenPos2 = make(map[pos2]bool)
-
 // dedup takes a token.Position pair and returns a pair that does not
 // duplicate any existing pair. The returned pair will have the Offset
 // fields cleared.
-func dedup(p1, p2 token.Position) (r1, r2 token.Position) {
+// QINIU, f.seenPos2 tracks whether we have seen a token.Position pair.
+func (f *File) dedup(p1, p2 token.Position) (r1, r2 token.Position) {
 	key := pos2{
 		p1: p1,
 		p2: p2,
@@ -770,10 +863,10 @@ func dedup(p1, p2 token.Position) (r1, r2 token.Position) {
 	key.p1.Offset = 0
 	key.p2.Offset = 0
 
-	for seenPos2[key] {
+	for f.seenPos2[key] {
 		key.p2.Column++
 	}
-	seenPos2[key] = true
+	f.seenPos2[key] = true
 
 	return key.p1, key.p2
 }
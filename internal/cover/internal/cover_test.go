@@ -0,0 +1,316 @@
+package tool
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testSource covers the syntax the walkers rewrite, with the ignore directives in all their places
+const testSource = `package main
+
+import "fmt"
+
+const debug = true && false
+
+type T[E any] struct{ items []E }
+
+func (t *T[E]) Len() int {
+	if t == nil || len(t.items) == 0 {
+		return 0
+	}
+	return len(t.items)
+}
+
+func (t T[E]) First() (e E) {
+	if len(t.items) > 0 {
+		e = t.items[0]
+	}
+	return
+}
+
+func init() {}
+
+func init() { fmt.Println(debug) }
+
+func kind(v any) string {
+	switch v.(type) {
+	case int:
+		return "int"
+	case string:
+		return "string"
+	}
+	return "other"
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0: //scope:ignore impossible
+		return -1
+	default:
+		return 0
+	}
+}
+
+func wait(c chan int) int {
+	select {
+	case v := <-c:
+		return v
+	default:
+		return 0
+	}
+}
+
+//scope:ignore debug only
+func dump() { fmt.Println("dump") }
+
+func main() {
+	x := 1 //scope:ignore
+	if x > 0 {
+		x++
+	}
+	//scope:ignore
+	for i := 0; i < x; i++ {
+		x--
+	}
+	t := &T[int]{}
+	fmt.Println(t.Len(), t.First(), kind(x), sign(x), wait(nil), x)
+	dump()
+}
+`
+
+// lineOf returns the line of the first occurrence of s in testSource
+func lineOf(t *testing.T, s string) int {
+	t.Helper()
+	i := strings.Index(testSource, s)
+	if i < 0 {
+		t.Fatalf("%q not found in the source", s)
+	}
+	return strings.Count(testSource[:i], "\n") + 1
+}
+
+// layout is the counter layout declared by Annotate
+type layout struct {
+	blocks      [][2]int // the start and end lines of the blocks
+	funcs       []string
+	branchTrue  [][2]int // the start and end lines of the true outcomes
+	branchFalse [][2]int
+}
+
+// covers reports whether any of the blocks spans the line
+func covers(blocks [][2]int, line int) bool {
+	for _, b := range blocks {
+		if b[0] <= line && line <= b[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLayout reads the counter layout from the declaration of the cover variable
+func parseLayout(t *testing.T, decl string) layout {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "decl.go", "package cover\n"+decl, 0)
+	if err != nil {
+		t.Fatalf("invalid declaration: %v\n%s", err, decl)
+	}
+	var l layout
+	positions := func(lit *ast.CompositeLit) [][2]int {
+		var res [][2]int
+		for i := 0; i+2 < len(lit.Elts); i += 3 {
+			start, _ := strconv.Atoi(lit.Elts[i].(*ast.BasicLit).Value)
+			end, _ := strconv.Atoi(lit.Elts[i+1].(*ast.BasicLit).Value)
+			res = append(res, [2]int{start, end})
+		}
+		return res
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		kv, ok := n.(*ast.KeyValueExpr)
+		if !ok {
+			return true
+		}
+		lit := kv.Value.(*ast.CompositeLit)
+		switch kv.Key.(*ast.Ident).Name {
+		case "Pos":
+			l.blocks = positions(lit)
+		case "BranchTruePos":
+			l.branchTrue = positions(lit)
+		case "BranchFalsePos":
+			l.branchFalse = positions(lit)
+		case "Func":
+			for _, e := range lit.Elts {
+				name, _ := strconv.Unquote(e.(*ast.BasicLit).Value)
+				l.funcs = append(l.funcs, name)
+			}
+		}
+		return false
+	})
+	return l
+}
+
+// annotate annotates testSource in the mode into dir/<mode>/main.go, and checks the result parses
+func annotate(t *testing.T, dir, mode string) (string, layout, string) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(name, []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, mode, "main.go")
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		t.Fatal(err)
+	}
+	decl, err := Annotate(name, dest, mode, "Cover"+strings.ToUpper(mode[:1])+mode[1:], "example.com/annotated/cover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), dest, out, 0); err != nil {
+		t.Fatalf("invalid annotated file: %v\n%s", err, out)
+	}
+	return string(out), parseLayout(t, decl), decl
+}
+
+func TestAnnotate(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		mode  string
+		check func(t *testing.T, out string, l layout)
+	}{
+		{"set", checkIgnored},
+		{"count", checkIgnored},
+		{"atomic", checkIgnored},
+		{"func", checkFunc},
+		{"branch", checkBranch},
+	}
+	decls := "package cover\n" + BranchFuncDecl
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			out, l, decl := annotate(t, dir, tt.mode)
+			decls += decl
+			tt.check(t, out, l)
+		})
+	}
+
+	// the annotated files compile against the declarations, as they do in the global cover var package
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	files := map[string]string{
+		"go.mod":         "module example.com/annotated\n\ngo 1.21\n",
+		"cover/cover.go": decls,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command("go", "vet", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go vet: %v\n%s", err, out)
+	}
+}
+
+// checkIgnored checks the blocks the ignore directives exclude in the modes counting the basic blocks
+func checkIgnored(t *testing.T, out string, l layout) {
+	// the trailing directive applies to its own line, not to the if on the next one
+	if !covers(l.blocks, lineOf(t, "x++")) {
+		t.Errorf("the block of line %d is ignored by the trailing directive of the line before", lineOf(t, "x++"))
+	}
+	for _, ignored := range []string{
+		"return -1",           // a trailing directive ignores its case
+		"x--",                 // a directive alone on its line ignores the statement of the next line
+		`fmt.Println("dump")`, // a directive in the doc comment ignores the function
+	} {
+		if covers(l.blocks, lineOf(t, ignored)) {
+			t.Errorf("the block of %s is not ignored", ignored)
+		}
+	}
+	for _, counted := range []string{"return 0\n\t}\n\treturn len", `return "string"`, "return v", "fmt.Println(debug)"} {
+		if !covers(l.blocks, lineOf(t, counted)) {
+			t.Errorf("no block for %s", counted)
+		}
+	}
+	if !strings.Contains(out, "const debug = true && false") {
+		t.Error("the constant expression is rewritten")
+	}
+}
+
+// checkFunc checks the func mode counts each function once under the name the runtime gives it
+func checkFunc(t *testing.T, out string, l layout) {
+	// dump is ignored by its doc comment
+	want := []string{"(*T).Len", "T.First", "init", "init.1", "kind", "sign", "wait", "main"}
+	if !reflect.DeepEqual(l.funcs, want) {
+		t.Errorf("functions %q, want %q", l.funcs, want)
+	}
+	if len(l.blocks) != len(l.funcs) {
+		t.Fatalf("%d blocks for %d functions", len(l.blocks), len(l.funcs))
+	}
+	// the block of a function spans it from the declaration to the closing brace
+	for i, fn := range []string{"func (t *T[E]) Len", "func (t T[E]) First", "func init() {}", "func init() { fmt", "func kind", "func sign", "func wait", "func main"} {
+		if start := lineOf(t, fn); l.blocks[i][0] != start {
+			t.Errorf("the block of %s starts at line %d, want %d", l.funcs[i], l.blocks[i][0], start)
+		}
+	}
+	if end := lineOf(t, "dump()\n}") + 1; l.blocks[len(l.blocks)-1][1] != end {
+		t.Errorf("the block of main ends at line %d, want %d", l.blocks[len(l.blocks)-1][1], end)
+	}
+}
+
+// checkBranch checks the branch mode counts the outcomes of the conditions and the switches
+// besides the blocks, except the ignored ones
+func checkBranch(t *testing.T, out string, l layout) {
+	checkIgnored(t, out, l)
+	lines := func(branches [][2]int) []int {
+		var res []int
+		for _, b := range branches {
+			res = append(res, b[0])
+		}
+		return res
+	}
+	// the condition of t == nil || len(t.items) == 0 and its two operands
+	cond := lineOf(t, "if t == nil")
+	tests := []struct {
+		name     string
+		branches [][2]int
+		want     []int
+	}{
+		{
+			// the conditions and the cases taken, the ignored case and the for are left out
+			name:     "BranchTrue",
+			branches: l.branchTrue,
+			want: []int{cond, cond, cond, lineOf(t, "if len(t.items) > 0"),
+				lineOf(t, "case int:"), lineOf(t, "case string:"), lineOf(t, "case n > 0:"), lineOf(t, "default:"), lineOf(t, "if x > 0")},
+		},
+		{
+			// the switches without default take no case, the select is not a branch
+			name:     "BranchFalse",
+			branches: l.branchFalse,
+			want:     []int{cond, cond, cond, lineOf(t, "if len(t.items) > 0"), lineOf(t, "switch v.(type)"), lineOf(t, "if x > 0")},
+		},
+	}
+	for _, tt := range tests {
+		if got := lines(tt.branches); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s at the lines %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if !strings.Contains(out, BranchFuncName+"(") {
+		t.Error("no condition is wrapped")
+	}
+}
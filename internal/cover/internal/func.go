@@ -0,0 +1,65 @@
+package tool
+
+import (
+	"fmt"
+	"go/ast"
+)
+
+// funcMode puts a single counter at the entry of each function instead of each basic block,
+// the block of the counter spans the whole function and is reported under the function name.
+const funcMode = "func"
+
+// visitFunc is the walker of the func mode, only the function declarations get counters
+func (f *File) visitFunc(node ast.Node) ast.Visitor {
+	fn, ok := node.(*ast.FuncDecl)
+	if !ok {
+		return f
+	}
+	if fn.Body == nil {
+		return nil
+	}
+	counter := f.newCounter(fn.Pos(), fn.Body.Rbrace+1, 1)
+	f.edit.Insert(f.offset(fn.Body.Lbrace+1), counter+";")
+	f.funcNames = append(f.funcNames, f.uniqueFuncName(funcName(fn)))
+	return nil
+}
+
+// funcName returns the name of the function as the runtime does, such as F, T.M or (*T).M
+func funcName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	typ := fn.Recv.List[0].Type
+	star := false
+	if s, ok := typ.(*ast.StarExpr); ok {
+		typ, star = s.X, true
+	}
+	// drop the type parameters of a generic receiver
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		typ = t.X
+	case *ast.IndexListExpr:
+		typ = t.X
+	}
+	recv := "?"
+	if ident, ok := typ.(*ast.Ident); ok {
+		recv = ident.Name
+	}
+	if star {
+		return fmt.Sprintf("(*%s).%s", recv, fn.Name.Name)
+	}
+	return fmt.Sprintf("%s.%s", recv, fn.Name.Name)
+}
+
+// uniqueFuncName numbers the repeated names in the file, such as init.1 for the second init
+func (f *File) uniqueFuncName(name string) string {
+	if f.seenFuncs == nil {
+		f.seenFuncs = make(map[string]int)
+	}
+	n := f.seenFuncs[name]
+	f.seenFuncs[name]++
+	if n == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s.%d", name, n)
+}
@@ -0,0 +1,78 @@
+package tool
+
+import (
+	"go/ast"
+	"strings"
+)
+
+// ignoreDirective excludes code from coverage, it may be followed by a space and a reason:
+//
+//	//scope:ignore mocked in the tests
+//
+// Before the package clause it ignores the whole file. In the doc comment of a function
+// it ignores the function. Alone on the line before a statement, or trailing on the line the statement starts,
+// it ignores the blocks of the statement, such as the branches of an if or the body of a for.
+// A trailing directive never applies to the statement on the next line.
+const ignoreDirective = "//scope:ignore"
+
+func isIgnoreDirective(c *ast.Comment) bool {
+	rest, ok := strings.CutPrefix(c.Text, ignoreDirective)
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t')
+}
+
+// skipFile reports whether the whole file is left uninstrumented,
+// either generated by a tool or ignored before the package clause
+func skipFile(file *ast.File) bool {
+	if ast.IsGenerated(file) {
+		return true
+	}
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+		for _, c := range group.List {
+			if isIgnoreDirective(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectIgnoreLines records the lines of the ignore directives after the package clause,
+// and whether each directive is alone on its line
+func (f *File) collectIgnoreLines() {
+	f.ignoreLines = make(map[int]bool)
+	for _, group := range f.astFile.Comments {
+		for _, c := range group.List {
+			if c.Pos() > f.astFile.Package && isIgnoreDirective(c) {
+				pos := f.fset.Position(c.Pos())
+				f.ignoreLines[pos.Line] = f.ignoreLines[pos.Line] || f.aloneOnLine(pos.Offset)
+			}
+		}
+	}
+}
+
+// aloneOnLine reports whether only blanks precede the offset on its line
+func (f *File) aloneOnLine(offset int) bool {
+	for i := offset - 1; i >= 0 && f.content[i] != '\n'; i-- {
+		if f.content[i] != ' ' && f.content[i] != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// ignored reports whether the node is excluded by a directive
+func (f *File) ignored(node ast.Node) bool {
+	if fn, ok := node.(*ast.FuncDecl); ok && fn.Doc != nil {
+		for _, c := range fn.Doc.List {
+			if isIgnoreDirective(c) {
+				return true
+			}
+		}
+	}
+	line := f.fset.Position(node.Pos()).Line
+	_, sameLine := f.ignoreLines[line]
+	return sameLine || f.ignoreLines[line-1]
+}
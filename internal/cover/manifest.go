@@ -0,0 +1,115 @@
+package cover
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// ManifestSuffix is appended to the name of the binary to name its manifest
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes what was instrumented into a binary. It is written next to the binary
+// and served by the injected agent, so a profile can be checked against the source it came from.
+type Manifest struct {
+	Module   string         `json:"module"`
+	Main     string         `json:"main"` // import path of the main package
+	Revision string         `json:"revision,omitempty"`
+	Modified bool           `json:"modified,omitempty"` // the working tree had uncommitted changes
+	Mode     string         `json:"mode"`
+	Backend  string         `json:"backend"`
+	Files    []ManifestFile `json:"files"`
+}
+
+// ManifestFile is an instrumented file of the manifest
+type ManifestFile struct {
+	Name   string `json:"name"`          // file name in the profile, such as example.com/pkg/file.go
+	Var    string `json:"var,omitempty"` // cover variable of the file, the native backend has none
+	SHA256 string `json:"sha256"`        // hash of the source before instrumentation
+	Blocks int    `json:"blocks"`        // counted blocks, unknown to the native backend and left 0
+}
+
+// newManifest collects the files of the covers instrumented into the main package
+func newManifest(coverInfo *CoverInfo, mainPkg *Package, covers []*PackageCover) *Manifest {
+	backend := coverInfo.Backend
+	if backend == "" {
+		backend = "goc"
+	}
+	m := &Manifest{
+		Module:   coverInfo.ModRootPath,
+		Main:     mainPkg.ImportPath,
+		Revision: coverInfo.Revision,
+		Modified: coverInfo.Modified,
+		Mode:     coverInfo.Mode,
+		Backend:  backend,
+		Files:    []ManifestFile{},
+	}
+	if backend == "native" {
+		m.Mode = "atomic"
+	}
+	for _, pc := range covers {
+		for _, fv := range pc.Vars {
+			m.Files = append(m.Files, ManifestFile{
+				Name:   fv.File,
+				Var:    fv.Var,
+				SHA256: fv.Hash,
+				Blocks: fv.Blocks,
+			})
+		}
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Name < m.Files[j].Name })
+	return m
+}
+
+// JSON returns the indented manifest
+func (m *Manifest) JSON() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// WriteFile writes the manifest to the file name
+func (m *Manifest) WriteFile(name string) error {
+	content, err := m.JSON()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(content, '\n'), 0644)
+}
+
+// fileHash returns the hex encoded sha256 of the file content
+func fileHash(name string) (string, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(content)), nil
+}
+
+// countFieldRe matches the counter array of the cover variable declared by tool.Annotate
+var countFieldRe = regexp.MustCompile(`\n\tCount\s+\[(\d+)\]uint32\n`)
+
+// blockCount returns the number of blocks counted by the cover variable declaration
+func blockCount(decl string) int {
+	match := countFieldRe.FindStringSubmatch(decl)
+	if match == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(match[1])
+	return n
+}
+
+// hashPackageFiles fills the hashes of the files of the package cover
+func hashPackageFiles(pc *PackageCover) error {
+	for file, fv := range pc.Vars {
+		hash, err := fileHash(path.Join(pc.Package.Dir, file))
+		if err != nil {
+			return err
+		}
+		fv.Hash = hash
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package cover
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+// ProfileFiles expands the directories in names to the profiles dumped into them,
+// the other names are taken as profiles
+func ProfileFiles(names []string) ([]string, error) {
+	var files []string
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, name)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(name, "*.cov"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// MergeProfileFiles merges the profiles, the counts of the same blocks are added up
+func MergeProfileFiles(files []string) ([]*cover.Profile, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no profiles to merge")
+	}
+	profiles := make([][]*cover.Profile, 0, len(files))
+	for _, file := range files {
+		p, err := cover.ParseProfiles(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse profile %s: %w", file, err)
+		}
+		profiles = append(profiles, p)
+	}
+	return cov.MergeMultipleProfiles(profiles)
+}
@@ -0,0 +1,69 @@
+package cover
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Overlay collects the instrumented files which replace the original ones at build time.
+// It is passed to the go command with the -overlay flag, so the source tree is left untouched.
+type Overlay struct {
+	Replace map[string]string `json:"Replace"`
+
+	mu  sync.Mutex
+	dir string // directory holding the instrumented files
+}
+
+// NewOverlay creates an overlay storing the instrumented files in dir
+func NewOverlay(dir string) *Overlay {
+	return &Overlay{
+		Replace: make(map[string]string),
+		dir:     dir,
+	}
+}
+
+// Path returns the path the content of file should be written to, and records the replacement.
+// A nil overlay means the files are instrumented in place.
+func (o *Overlay) Path(file string) (string, error) {
+	if o == nil {
+		return file, nil
+	}
+	sum := sha256.Sum256([]byte(filepath.Dir(file)))
+	dest := filepath.Join(o.dir, fmt.Sprintf("%x", sum[:6]), filepath.Base(file))
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return "", err
+	}
+	o.mu.Lock()
+	o.Replace[file] = dest
+	o.mu.Unlock()
+	return dest, nil
+}
+
+// Current returns the path holding the current content of file, the replacement if any.
+// A nil overlay means the files are instrumented in place.
+func (o *Overlay) Current(file string) string {
+	if o == nil {
+		return file
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if dest, ok := o.Replace[file]; ok {
+		return dest
+	}
+	return file
+}
+
+// WriteFile writes the overlay as the json file expected by the -overlay flag
+func (o *Overlay) WriteFile(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	content, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, content, 0644)
+}
@@ -0,0 +1,308 @@
+package cover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spelens-gud/logger"
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+// pushAddressPrefix marks the addresses of the instances pushing their profiles,
+// they are listed and selected like the registered addresses, but never called
+const pushAddressPrefix = "push://"
+
+// expiredAddressPrefix marks the address keeping the merged profiles of the expired instances of a service
+const expiredAddressPrefix = pushAddressPrefix + "expired/"
+
+// defaultPushInterval is assumed for the instances not telling their push interval
+const defaultPushInterval = time.Minute
+
+// an instance is stale once it misses staleAfterPushes pushes in a row
+const staleAfterPushes = 3
+
+// ErrServiceNotPushed represents the instance has not pushed a profile, or it has been removed
+var ErrServiceNotPushed = errors.New("no profile pushed by the instance")
+
+// pushedProfile is the latest profile pushed by an instance of a service
+type pushedProfile struct {
+	Name     string        `json:"name"`
+	Address  string        `json:"address"`
+	Profile  []byte        `json:"profile"`
+	Time     time.Time     `json:"time"`
+	Interval time.Duration `json:"interval,omitempty"` // the push interval of the instance, 0 for the expired ones
+}
+
+// staleSince returns when the instance became stale by missing its pushes, zero if it is not stale,
+// or if it keeps the profiles of the expired instances
+func (p *pushedProfile) staleSince(now time.Time) time.Time {
+	if isExpiredAddress(p.Address) {
+		return time.Time{}
+	}
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultPushInterval
+	}
+	since := p.Time.Add(staleAfterPushes * interval)
+	if now.Before(since) {
+		return time.Time{}
+	}
+	return since
+}
+
+// pushedProfiles keeps the latest profile of each pushing instance, keyed by the push address.
+// With a directory, each profile is also written into a file under it, so they survive a restart of the center.
+type pushedProfiles struct {
+	mu       sync.RWMutex
+	dir      string
+	profiles map[string]*pushedProfile
+}
+
+func isPushAddress(address string) bool {
+	return strings.HasPrefix(address, pushAddressPrefix)
+}
+
+func isExpiredAddress(address string) bool {
+	return strings.HasPrefix(address, expiredAddressPrefix)
+}
+
+// load sets the directory the profiles are persisted into, and loads the ones persisted before
+func (p *pushedProfiles) load(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dir = dir
+	p.profiles = make(map[string]*pushedProfile)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var profile pushedProfile
+		if err := json.Unmarshal(content, &profile); err != nil {
+			return fmt.Errorf("invalid pushed profile %s: %w", file, err)
+		}
+		p.profiles[profile.Address] = &profile
+	}
+	return nil
+}
+
+// file returns the file the profile of the address is persisted into
+func (p *pushedProfiles) file(address string) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(address))))
+}
+
+func (p *pushedProfiles) write(profile *pushedProfile) error {
+	if p.dir == "" {
+		return nil
+	}
+	content, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so a crash never leaves a truncated profile behind
+	file := p.file(profile.Address)
+	if err := os.WriteFile(file+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func (p *pushedProfiles) delete(address string) {
+	delete(p.profiles, address)
+	if p.dir == "" {
+		return
+	}
+	if err := os.Remove(p.file(address)); err != nil && !os.IsNotExist(err) {
+		logger.Warnf("failed to remove the pushed profile of %s, err: %v", address, err)
+	}
+}
+
+func (p *pushedProfiles) put(profile *pushedProfile) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.profiles == nil {
+		p.profiles = make(map[string]*pushedProfile)
+	}
+	if err := p.write(profile); err != nil {
+		return err
+	}
+	p.profiles[profile.Address] = profile
+	return nil
+}
+
+func (p *pushedProfiles) get(address string) (*pushedProfile, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	profile, ok := p.profiles[address]
+	return profile, ok
+}
+
+func (p *pushedProfiles) remove(address string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.profiles[address]
+	p.delete(address)
+	return ok
+}
+
+func (p *pushedProfiles) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for address := range p.profiles {
+		p.delete(address)
+	}
+}
+
+// addTo adds the push addresses to the services by name
+func (p *pushedProfiles) addTo(services map[string][]string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for address, profile := range p.profiles {
+		services[profile.Name] = append(services[profile.Name], address)
+	}
+}
+
+// expire merges the profiles of the instances stale for longer than evictAfter
+// into the expired profile of their services, so the coverage is kept without an entry for each gone instance
+func (p *pushedProfiles) expire(now time.Time, evictAfter time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var expired []*pushedProfile
+	for _, profile := range p.profiles {
+		if since := profile.staleSince(now); !since.IsZero() && now.Sub(since) >= evictAfter {
+			expired = append(expired, profile)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Time.Before(expired[j].Time) })
+
+	for _, profile := range expired {
+		address := expiredAddressPrefix + profile.Name
+		merged := &pushedProfile{Name: profile.Name, Address: address, Profile: profile.Profile, Time: profile.Time}
+		if archive, ok := p.profiles[address]; ok {
+			content, err := mergeProfiles(archive.Profile, profile.Profile)
+			if err != nil {
+				// the instance may be built from another revision, its blocks do not match the others
+				logger.Warnf("dropped the profile of the expired instance %s of service %s, err: %v", profile.Address, profile.Name, err)
+				p.delete(profile.Address)
+				continue
+			}
+			merged.Profile = content
+		}
+		if err := p.write(merged); err != nil {
+			logger.Errorf("failed to keep the profile of the expired instance %s of service %s, err: %v", profile.Address, profile.Name, err)
+			continue
+		}
+		p.profiles[address] = merged
+		p.delete(profile.Address)
+		logger.Warnf("expired the instance %s of service %s, last pushed at %s", profile.Address, profile.Name, profile.Time.Format(time.RFC3339))
+	}
+}
+
+// mergeProfiles merges the profiles in the text format, the counts of the same blocks are added up
+func mergeProfiles(contents ...[]byte) ([]byte, error) {
+	profiles := make([][]*cover.Profile, 0, len(contents))
+	for _, content := range contents {
+		profile, err := convertProfile(content)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	merged, err := cov.MergeMultipleProfiles(profiles)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := cov.DumpProfile(merged, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pushProfile API keeps the profile pushed by an instance, replacing the one it pushed before.
+// POST /v1/cover/push?name=service&instance=id&interval=30s with the profile as the body,
+// the instance is expired like an unhealthy service once it misses its pushes for the eviction grace period
+func (s *server) pushProfile(c *gin.Context) {
+	name, instance := c.Query("name"), c.Query("instance")
+	if strings.TrimSpace(name) == "" || strings.TrimSpace(instance) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and instance are required"})
+		return
+	}
+	interval := defaultPushInterval
+	if param := c.Query("interval"); param != "" {
+		d, err := time.ParseDuration(param)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid interval %q", param)})
+			return
+		}
+		interval = d
+	}
+	address := pushAddressPrefix + instance
+	if isExpiredAddress(address) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reserved instance " + instance})
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := convertProfile(body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile: " + err.Error()})
+		return
+	}
+
+	profile := &pushedProfile{Name: name, Address: address, Profile: body, Time: s.clock(), Interval: interval}
+	if err := s.pushed.put(profile); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "success"})
+}
+
+// allServices returns the registered services along with the pushing instances
+func (s *server) allServices() map[string][]string {
+	services := s.Store.GetAll()
+	s.pushed.addTo(services)
+	return services
+}
+
+// fetchProfile gets the profile of the address, from the latest push of a pushing instance
+func (s *server) fetchProfile(address string) ([]byte, error) {
+	if !isPushAddress(address) {
+		return NewWorker(address).Profile(ProfileParam{})
+	}
+	profile, ok := s.pushed.get(address)
+	if !ok {
+		return nil, ErrServiceNotPushed
+	}
+	return profile.Profile, nil
+}
+
+// pushIntervalString formats the push interval for the agent, empty if the push mode is off
+func pushIntervalString(interval time.Duration) string {
+	if interval <= 0 {
+		return ""
+	}
+	return interval.String()
+}
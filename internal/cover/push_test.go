@@ -0,0 +1,170 @@
+package cover
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testPushProfile is a profile of a single block executed count times
+func testPushProfile(count int) string {
+	return fmt.Sprintf("mode: count\nexample.com/app/main.go:3.13,5.2 2 %d\n", count)
+}
+
+func (c *testCenter) push(name, instance, interval string, count int) {
+	c.t.Helper()
+	query := url.Values{"name": {name}, "instance": {instance}, "interval": {interval}}
+	c.do(http.MethodPost, "/v1/cover/push?"+query.Encode(), testPushProfile(count), http.StatusOK)
+}
+
+// count returns the count of the block in the merged profile of the service
+func (c *testCenter) count(name string) int {
+	c.t.Helper()
+	profiles, err := convertProfile([]byte(c.do(http.MethodGet, "/v1/cover/profile?service="+name, "", http.StatusOK)))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if len(profiles) != 1 || len(profiles[0].Blocks) != 1 {
+		c.t.Fatalf("unexpected profile of %s: %+v", name, profiles)
+	}
+	return profiles[0].Blocks[0].Count
+}
+
+func TestPushReplacesProfile(t *testing.T) {
+	c := newTestCenter(t, NewMemoryBasedServer())
+	c.push("svc", "a", "10s", 3)
+	c.push("svc", "a", "10s", 5)
+	// each push is a snapshot of the counters of the instance, they are not added up
+	if got := c.count("svc"); got != 5 {
+		t.Errorf("count %d after pushing twice from an instance, want 5", got)
+	}
+	c.push("svc", "b", "10s", 2)
+	if got := c.count("svc"); got != 7 {
+		t.Errorf("count %d of two instances, want 7", got)
+	}
+	if got, want := c.services(), map[string][]string{"svc": {"push://a", "push://b"}}; !sameServices(got, want) {
+		t.Errorf("services %v, want %v", got, want)
+	}
+}
+
+func TestPushInvalid(t *testing.T) {
+	c := newTestCenter(t, NewMemoryBasedServer())
+	for _, query := range []string{
+		"instance=a",
+		"name=svc",
+		"name=svc&instance=a&interval=x",
+		"name=svc&instance=a&interval=-1s",
+		"name=svc&instance=expired/svc",
+	} {
+		c.do(http.MethodPost, "/v1/cover/push?"+query, testPushProfile(1), http.StatusBadRequest)
+	}
+	c.do(http.MethodPost, "/v1/cover/push?name=svc&instance=a", "not a profile", http.StatusBadRequest)
+	if got := c.services(); len(got) != 0 {
+		t.Errorf("services %v after the invalid pushes", got)
+	}
+}
+
+func TestPushExpires(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "store")
+	s, err := NewFileBasedServer(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EvictAfter = time.Minute
+	c := newTestCenter(t, s)
+	start := c.now
+	c.push("svc", "a", "10s", 3)
+	c.push("svc", "b", "10s", 4)
+	c.push("other", "c", "1h", 1)
+
+	// an instance is unhealthy once it misses staleAfterPushes pushes
+	c.advance(staleAfterPushes*10*time.Second - time.Second)
+	c.push("svc", "b", "10s", 5)
+	c.advance(time.Second)
+	health := c.health()
+	for _, h := range health {
+		switch h.Address {
+		case "push://a":
+			if h.Status != HealthUnhealthy || h.UnhealthySince == nil || !h.UnhealthySince.Equal(c.now) {
+				t.Errorf("a: %+v, want unhealthy since %s", h, c.now)
+			}
+		default:
+			if h.Status != HealthPushed || h.UnhealthySince != nil {
+				t.Errorf("%s: %+v, want pushed", h.Address, h)
+			}
+		}
+	}
+
+	// the stale instance is merged into the expired profile after EvictAfter
+	c.advance(s.EvictAfter - time.Second)
+	s.probeAll()
+	if got := len(c.services()["svc"]); got != 2 {
+		t.Errorf("%d instances of svc before EvictAfter, want 2", got)
+	}
+	c.advance(time.Second)
+	s.probeAll()
+	want := map[string][]string{"svc": {"push://b", "push://expired/svc"}, "other": {"push://c"}}
+	if got := c.services(); !sameServices(got, want) {
+		t.Errorf("services %v after the expiry, want %v", got, want)
+	}
+	if got := c.count("svc"); got != 8 {
+		t.Errorf("count %d with the expired instance, want 8", got)
+	}
+
+	// the next expired instance is added up into the same profile
+	c.advance(staleAfterPushes*10*time.Second + s.EvictAfter)
+	s.probeAll()
+	want = map[string][]string{"svc": {"push://expired/svc"}, "other": {"push://c"}}
+	if got := c.services(); !sameServices(got, want) {
+		t.Errorf("services %v after the second expiry, want %v", got, want)
+	}
+	if got := c.count("svc"); got != 8 {
+		t.Errorf("count %d of the expired profile, want 8", got)
+	}
+	for _, h := range c.health() {
+		if h.Address == "push://expired/svc" && (h.Status != HealthExpired || !h.LastSeen.Equal(start.Add(staleAfterPushes*10*time.Second-time.Second))) {
+			t.Errorf("expired: %+v, want expired with the time of the last push", h)
+		}
+	}
+
+	// the pushed and the expired profiles survive a restart of the center
+	restarted, err := NewFileBasedServer(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = newTestCenter(t, restarted)
+	if got := c.services(); !sameServices(got, want) {
+		t.Errorf("services %v after the restart, want %v", got, want)
+	}
+	if got := c.count("svc"); got != 8 {
+		t.Errorf("count %d after the restart, want 8", got)
+	}
+
+	// the expired profile is removed like the other instances
+	c.do(http.MethodPost, "/v1/cover/remove", `{"address":["push://expired/svc"]}`, http.StatusOK)
+	if got, want := c.services(), map[string][]string{"other": {"push://c"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("services %v after the removal, want %v", got, want)
+	}
+}
+
+// sameServices compares the services regardless of the order of the addresses
+func sameServices(got, want map[string][]string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for name, addrs := range want {
+		if len(got[name]) != len(addrs) {
+			return false
+		}
+		for _, addr := range addrs {
+			if !contains(got[name], addr) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,315 @@
+package cover
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// ReportFile is the coverage of a source file in the report
+type ReportFile struct {
+	Name     string
+	Summary  Summary
+	Branches []ReportBranch // the outcomes of the conditions in branch mode, sorted by position
+	Mismatch string         // why the source differs from the instrumented one, if it does
+	Missing  bool           // the source is not found in the checkout
+	Source   template.HTML
+}
+
+// ReportBranch is how many times a condition is true and false.
+// A switch case only has the true outcome, taken, and a switch without default only the false one, no case taken.
+type ReportBranch struct {
+	Line     int
+	Col      int
+	EndLine  int
+	EndCol   int
+	True     int
+	False    int
+	HasTrue  bool
+	HasFalse bool
+}
+
+// SourceBranches pairs the true and false outcomes of the branches of branch mode by their ranges, keyed by the source file.
+// The whole ranges are compared, as a condition and its first operand start at the same position.
+func SourceBranches(profiles []*cover.Profile) map[string][]ReportBranch {
+	type pos struct{ line, col, endLine, endCol int }
+	byPos := make(map[string]map[pos]*ReportBranch)
+	for _, p := range profiles {
+		if !IsBranchFile(p.FileName) {
+			continue
+		}
+		name := sourceFileName(p.FileName)
+		if byPos[name] == nil {
+			byPos[name] = make(map[pos]*ReportBranch)
+		}
+		for _, b := range p.Blocks {
+			k := pos{b.StartLine, b.StartCol, b.EndLine, b.EndCol}
+			rb, ok := byPos[name][k]
+			if !ok {
+				rb = &ReportBranch{Line: b.StartLine, Col: b.StartCol, EndLine: b.EndLine, EndCol: b.EndCol}
+				byPos[name][k] = rb
+			}
+			if strings.HasSuffix(p.FileName, branchTrueSuffix) {
+				rb.True, rb.HasTrue = rb.True+b.Count, true
+			} else {
+				rb.False, rb.HasFalse = rb.False+b.Count, true
+			}
+		}
+	}
+
+	branches := make(map[string][]ReportBranch, len(byPos))
+	for name, m := range byPos {
+		for _, rb := range m {
+			branches[name] = append(branches[name], *rb)
+		}
+		sort.Slice(branches[name], func(i, j int) bool {
+			bi, bj := branches[name][i], branches[name][j]
+			if bi.Line != bj.Line || bi.Col != bj.Col {
+				return bi.Line < bj.Line || bi.Line == bj.Line && bi.Col < bj.Col
+			}
+			return bi.EndLine > bj.EndLine || bi.EndLine == bj.EndLine && bi.EndCol > bj.EndCol
+		})
+	}
+	return branches
+}
+
+// SummarizeBranches counts the outcomes of the branches
+func SummarizeBranches(branches []ReportBranch) Summary {
+	var s Summary
+	for _, b := range branches {
+		if b.HasTrue {
+			s.Branches++
+			if b.True > 0 {
+				s.CoveredBranches++
+			}
+		}
+		if b.HasFalse {
+			s.Branches++
+			if b.False > 0 {
+				s.CoveredBranches++
+			}
+		}
+	}
+	return s
+}
+
+// SourceProfiles groups the blocks of the profiles by source file, sorted by the file name.
+// The functions of func mode are put together, and the branches of branch mode are left out.
+func SourceProfiles(profiles []*cover.Profile) []*cover.Profile {
+	bySource := make(map[string]*cover.Profile)
+	var out []*cover.Profile
+	for _, p := range profiles {
+		if IsBranchFile(p.FileName) {
+			continue
+		}
+		name := sourceFileName(p.FileName)
+		sp, ok := bySource[name]
+		if !ok {
+			sp = &cover.Profile{FileName: name, Mode: p.Mode}
+			bySource[name] = sp
+			out = append(out, sp)
+		}
+		sp.Blocks = append(sp.Blocks, p.Blocks...)
+	}
+	for _, sp := range out {
+		sort.Slice(sp.Blocks, func(i, j int) bool {
+			bi, bj := sp.Blocks[i], sp.Blocks[j]
+			return bi.StartLine < bj.StartLine || bi.StartLine == bj.StartLine && bi.StartCol < bj.StartCol
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FileName < out[j].FileName })
+	return out
+}
+
+// RenderHTML writes the sources of the profiles in the checkout annotated with their coverage as a html page.
+// The hit counts of count and atomic modes are shown as heat intensity, on a log scale.
+func RenderHTML(w io.Writer, profiles []*cover.Profile, checkout *Checkout, mismatches []Mismatch) error {
+	reasons := make(map[string]string)
+	for _, m := range mismatches {
+		reasons[m.File] = m.Reason
+	}
+
+	branches := SourceBranches(profiles)
+	var files []ReportFile
+	var total Summary
+	for _, p := range SourceProfiles(profiles) {
+		f := ReportFile{
+			Name:     p.FileName,
+			Summary:  Summarize([]*cover.Profile{p}),
+			Branches: branches[p.FileName],
+			Mismatch: reasons[p.FileName],
+		}
+		bs := SummarizeBranches(f.Branches)
+		f.Summary.Branches, f.Summary.CoveredBranches = bs.Branches, bs.CoveredBranches
+		total.Statements += f.Summary.Statements
+		total.CoveredStatements += f.Summary.CoveredStatements
+		total.Branches += f.Summary.Branches
+		total.CoveredBranches += f.Summary.CoveredBranches
+
+		src, err := readSource(checkout, p.FileName)
+		if err != nil {
+			f.Missing = true
+			files = append(files, f)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := htmlGen(&buf, src, p.Boundaries(src)); err != nil {
+			return err
+		}
+		f.Source = template.HTML(buf.String())
+		files = append(files, f)
+	}
+
+	return reportTmpl.Execute(w, struct {
+		Files []ReportFile
+		Total Summary
+	}{files, total})
+}
+
+func readSource(checkout *Checkout, name string) ([]byte, error) {
+	local, ok := checkout.Path(name)
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the module %s", name, checkout.Module)
+	}
+	return os.ReadFile(local)
+}
+
+// htmlGen annotates the source with the boundaries of the blocks as go tool cover does,
+// the blocks beyond the end of a modified source are closed at the end
+func htmlGen(w io.Writer, src []byte, boundaries []cover.Boundary) error {
+	dst := bufio.NewWriter(w)
+	open := 0
+	emit := func(b cover.Boundary) {
+		if !b.Start {
+			if open > 0 {
+				dst.WriteString("</span>")
+				open--
+			}
+			return
+		}
+		n := 0
+		if b.Count > 0 {
+			n = int(math.Floor(b.Norm*9)) + 1
+		}
+		fmt.Fprintf(dst, `<span class="cov%v" title="%v">`, n, b.Count)
+		open++
+	}
+	for i := range src {
+		for len(boundaries) > 0 && boundaries[0].Offset == i {
+			emit(boundaries[0])
+			boundaries = boundaries[1:]
+		}
+		switch b := src[i]; b {
+		case '>':
+			dst.WriteString("&gt;")
+		case '<':
+			dst.WriteString("&lt;")
+		case '&':
+			dst.WriteString("&amp;")
+		case '\t':
+			dst.WriteString("        ")
+		default:
+			dst.WriteByte(b)
+		}
+	}
+	for ; open > 0; open-- {
+		dst.WriteString("</span>")
+	}
+	return dst.Flush()
+}
+
+var reportTmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": Percent,
+}).Parse(reportHTML))
+
+const reportHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage Report</title>
+<style>
+body { background: black; color: rgb(80, 80, 80); margin: 0; font-family: Menlo, monospace; }
+#topbar { background: black; position: fixed; top: 0; left: 0; right: 0; height: 42px; border-bottom: 1px solid rgb(80, 80, 80); padding: 0 10px; }
+#nav, #legend { float: left; margin-top: 10px; margin-right: 10px; }
+#legend span { margin: 0 5px; }
+#content { margin-top: 50px; }
+pre { font-size: 14px; padding: 0 10px; }
+.mismatch { color: rgb(255, 200, 0); padding: 0 10px; }
+table.branches { font-size: 14px; margin: 0 10px; border-collapse: collapse; }
+table.branches td, table.branches th { padding: 0 10px; text-align: right; }
+.cov0 { color: rgb(192, 0, 0) }
+.cov1 { color: rgb(128, 128, 128) }
+.cov2 { color: rgb(116, 140, 131) }
+.cov3 { color: rgb(104, 152, 134) }
+.cov4 { color: rgb(92, 164, 137) }
+.cov5 { color: rgb(80, 176, 140) }
+.cov6 { color: rgb(68, 188, 143) }
+.cov7 { color: rgb(56, 200, 146) }
+.cov8 { color: rgb(44, 212, 149) }
+.cov9 { color: rgb(32, 224, 152) }
+.cov10 { color: rgb(20, 236, 155) }
+</style>
+</head>
+<body>
+<div id="topbar">
+	<div id="nav">
+		<select id="files">
+		{{range $i, $f := .Files}}
+		<option value="file{{$i}}">{{$f.Name}} ({{percent $f.Summary.CoveredStatements $f.Summary.Statements}}{{if $f.Branches}}, branches {{percent $f.Summary.CoveredBranches $f.Summary.Branches}}{{end}}){{if $f.Mismatch}} !{{end}}</option>
+		{{end}}
+		</select>
+		total: {{percent .Total.CoveredStatements .Total.Statements}}{{if .Total.Branches}}, branches: {{percent .Total.CoveredBranches .Total.Branches}}{{end}}
+	</div>
+	<div id="legend">
+		<span>not tracked</span>
+		<span class="cov0">not covered</span>
+		<span class="cov1">low</span>
+		<span class="cov4">*</span>
+		<span class="cov7">*</span>
+		<span class="cov10">high hit count</span>
+	</div>
+</div>
+<div id="content">
+{{range $i, $f := .Files}}
+<div id="file{{$i}}" style="display: none">
+{{if $f.Mismatch}}<p class="mismatch">{{$f.Name}}: {{$f.Mismatch}}, the annotations may be misplaced</p>{{end}}
+{{if $f.Branches}}
+<table class="branches">
+<tr><th>condition</th><th>true</th><th>false</th></tr>
+{{range $f.Branches}}<tr><td>{{.Line}}:{{.Col}}-{{.EndLine}}:{{.EndCol}}</td>{{if .HasTrue}}<td class="{{if .True}}cov8{{else}}cov0{{end}}">{{.True}}</td>{{else}}<td>-</td>{{end}}{{if .HasFalse}}<td class="{{if .False}}cov8{{else}}cov0{{end}}">{{.False}}</td>{{else}}<td>-</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+{{if $f.Missing}}<p class="mismatch">{{$f.Name}}: source not found in the source root</p>{{else}}<pre>{{$f.Source}}</pre>{{end}}
+</div>
+{{end}}
+</div>
+<script>
+(function() {
+	var files = document.getElementById('files');
+	var visible;
+	function select(id) {
+		if (visible) visible.style.display = 'none';
+		visible = document.getElementById(id);
+		if (!visible) return;
+		visible.style.display = 'block';
+		window.scrollTo(0, 0);
+		location.hash = id;
+	}
+	files.addEventListener('change', function() { select(files.value); }, false);
+	if (location.hash && document.getElementById(location.hash.substring(1))) {
+		files.value = location.hash.substring(1);
+	}
+	select(files.value);
+})();
+</script>
+</body>
+</html>
+`
@@ -2,6 +2,8 @@ package cover
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,6 +14,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spelens-gud/logger"
@@ -27,8 +30,23 @@ type ServiceUnderTest struct {
 
 type server struct {
 	PersistenceFile string
-	IPRevise        bool // whether to do ip revise during registering
+	IPRevise        bool          // whether to do ip revise during registering
+	SourceRoot      string        // directory of the module the reports read the sources from, no reports if empty
+	ProbeInterval   time.Duration // interval of probing the registered services, no probes if 0
+	EvictAfter      time.Duration // remove the services unhealthy for longer than it, never if 0
 	Store           Store
+
+	pushed pushedProfiles   // the latest profiles pushed by the instances
+	health healthStates     // the probe states of the registered addresses
+	now    func() time.Time // the clock of the pushes and the probes, time.Now if nil
+}
+
+// clock returns the current time of the server
+func (s *server) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
 }
 
 // NewMemoryBasedServer new a memory based server without persistenceFile
@@ -37,20 +55,55 @@ func NewMemoryBasedServer() *server {
 		Store: NewMemoryStore(),
 	}
 }
+
+// NewFileBasedServer new a file based server with persistenceFile,
+// registered services are kept in the file and the pushed profiles in the directory besides it,
+// so they survive a restart
+func NewFileBasedServer(persistenceFile string) (*server, error) {
+	store, err := NewFileStore(persistenceFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		PersistenceFile: persistenceFile,
+		Store:           store,
+	}
+	if err := s.pushed.load(persistenceFile + ".pushed"); err != nil {
+		return nil, fmt.Errorf("load pushed profiles failed, err: %w", err)
+	}
+	return s, nil
+}
+
+// Run starts coverage host center
+func (s *server) Run(port string) error {
+	if s.ProbeInterval > 0 {
+		go s.probeLoop()
+	}
+	return s.Route(os.Stdout).Run(port)
+}
+
 func (s *server) Route(w io.Writer) *gin.Engine {
 	if w != nil {
 		gin.DefaultWriter = w
 	}
 	r := gin.Default()
 	// api to show the registered services
-	r.StaticFile("static", "./"+s.PersistenceFile)
+	if s.PersistenceFile != "" {
+		r.StaticFile("static", s.PersistenceFile)
+	}
 
 	v1 := r.Group("/v1")
 	{
 		v1.POST("/cover/register", s.registerService)
+		v1.POST("/cover/heartbeat", s.heartbeat)
+		v1.POST("/cover/push", s.pushProfile)
 		v1.GET("/cover/profile", s.profile)
 		v1.POST("/cover/profile", s.profile)
 		v1.POST("/cover/clear", s.clear)
+		v1.GET("/cover/manifest", s.manifest)
+		v1.POST("/cover/manifest", s.manifest)
+		v1.GET("/cover/report", s.report)
+		v1.POST("/cover/report", s.report)
 		v1.POST("/cover/init", s.initSystem)
 		v1.GET("/cover/list", s.listServices)
 		v1.POST("/cover/remove", s.removeServices)
@@ -68,9 +121,21 @@ type ProfileParam struct {
 	SkipFilePatterns  []string `form:"skipfile" json:"skipfile"`
 }
 
-// listServices list all the registered services
+// ServiceResult is the result of an operation applied to one registered service
+type ServiceResult struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+	Result  string `json:"result"`
+}
+
+// listServices list all the registered services,
+// or the health of each instance with /v1/cover/list?health=true
 func (s *server) listServices(c *gin.Context) {
-	services := s.Store.GetAll()
+	if health, _ := strconv.ParseBool(c.Query("health")); health {
+		c.JSON(http.StatusOK, s.servicesHealth())
+		return
+	}
+	services := s.allServices()
 	c.JSON(http.StatusOK, services)
 }
 
@@ -80,19 +145,56 @@ func (s *server) registerService(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !s.reviseAddress(c, &service) {
+		return
+	}
+
+	address := s.Store.Get(service.Name)
+	if !contains(address, service.Address) {
+		if err := s.Store.Add(service); err != nil && err != ErrServiceAlreadyRegistered {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": "success"})
+}
+
+// heartbeat API tells the agent whether its address is still registered,
+// the agent registers again on 404, such as after the center restarted with a memory store.
+// POST /v1/cover/heartbeat?name=service&address=http://host:port
+func (s *server) heartbeat(c *gin.Context) {
+	var service ServiceUnderTest
+	if err := c.ShouldBind(&service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.reviseAddress(c, &service) {
+		return
+	}
 
+	if !contains(s.Store.Get(service.Name), service.Address) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "service not registered"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "success"})
+}
+
+// reviseAddress validates the address of the service and normalizes it the way it is registered,
+// the error response is written if it is invalid
+func (s *server) reviseAddress(c *gin.Context, service *ServiceUnderTest) bool {
 	u, err := url.Parse(service.Address)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("url.Parse %s failed: %s", service.Address, err.Error())})
-		return
+		return false
 	}
 	if u.Scheme != "https" && u.Scheme != "http" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupport schema"})
-		return
+		return false
 	}
 	if u.Host == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "empty host"})
-		return
+		return false
 	}
 	host, port, err := net.SplitHostPort(u.Host)
 	if err != nil {
@@ -101,7 +203,7 @@ func (s *server) registerService(c *gin.Context) {
 			host = u.Host
 		} else {
 			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("net.SplitHostPort %s failed: %s", u.Host, err.Error())})
-			return
+			return false
 		}
 	}
 
@@ -111,7 +213,7 @@ func (s *server) registerService(c *gin.Context) {
 		doIPRevise, err = strconv.ParseBool(service.IPRevise)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("strconv.ParseBool %s failed: %s", service.IPRevise, err.Error())})
-			return
+			return false
 		}
 	} else {
 		doIPRevise = s.IPRevise
@@ -131,16 +233,7 @@ func (s *server) registerService(c *gin.Context) {
 	if port != "" {
 		service.Address = fmt.Sprintf("%s:%s", service.Address, port)
 	}
-
-	address := s.Store.Get(service.Name)
-	if !contains(address, service.Address) {
-		if err := s.Store.Add(service); err != nil && err != ErrServiceAlreadyRegistered {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-	}
-
-	c.JSON(http.StatusOK, gin.H{"result": "success"})
+	return true
 }
 
 // profile API examples:
@@ -153,65 +246,144 @@ func (s *server) profile(c *gin.Context) {
 		return
 	}
 
-	allInfos := s.Store.GetAll()
-	filterAddrInfoList, err := filterAddrInfo(body.Service, body.Address, body.Force, allInfos)
+	merged, status, err := s.mergedProfile(body)
 	if err != nil {
-		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := cov.DumpProfile(merged, c.Writer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+}
+
+// mergedProfile merges the profiles of the services selected by the param,
+// the http status is returned along with the error
+func (s *server) mergedProfile(body ProfileParam) ([]*cover.Profile, int, error) {
+	allInfos := s.allServices()
+	filterAddrInfoList, err := filterAddrInfo(body.Service, body.Address, body.Force, allInfos)
+	if err != nil {
+		return nil, http.StatusExpectationFailed, err
+	}
+
 	var mergedProfiles = make([][]*cover.Profile, 0)
 	for _, addrInfo := range filterAddrInfoList {
-		pp, err := NewWorker(addrInfo.Address).Profile(ProfileParam{})
+		pp, err := s.fetchProfile(addrInfo.Address)
 		if err != nil {
 			if body.Force {
 				logger.Warnf("get profile from [%s] failed, error: %s", addrInfo, err.Error())
 				continue
 			}
 
-			c.JSON(http.StatusExpectationFailed, gin.H{"error": fmt.Sprintf("failed to get profile from %s, service %s, error %s", addrInfo.Address, addrInfo.Name, err.Error())})
-			return
+			return nil, http.StatusExpectationFailed, fmt.Errorf("failed to get profile from %s, service %s, error %s", addrInfo.Address, addrInfo.Name, err.Error())
 		}
 
 		profile, err := convertProfile(pp)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return nil, http.StatusInternalServerError, err
 		}
 		mergedProfiles = append(mergedProfiles, profile)
 	}
 
 	if len(mergedProfiles) == 0 {
-		c.JSON(http.StatusExpectationFailed, gin.H{"error": "no profiles"})
-		return
+		return nil, http.StatusExpectationFailed, errors.New("no profiles")
 	}
 
 	merged, err := cov.MergeMultipleProfiles(mergedProfiles)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, http.StatusInternalServerError, err
 	}
 
 	if len(body.CoverFilePatterns) > 0 {
 		merged, err = filterProfile(body.CoverFilePatterns, merged)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to filter profile based on the patterns: %v, error: %v", body.CoverFilePatterns, err)})
-			return
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to filter profile based on the patterns: %v, error: %v", body.CoverFilePatterns, err)
 		}
 	}
 
 	if len(body.SkipFilePatterns) > 0 {
 		merged, err = skipProfile(body.SkipFilePatterns, merged)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to skip profile based on the patterns: %v, error: %v", body.SkipFilePatterns, err)})
-			return
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to skip profile based on the patterns: %v, error: %v", body.SkipFilePatterns, err)
 		}
 	}
 
-	if err := cov.DumpProfile(merged, c.Writer); err != nil {
+	return merged, http.StatusOK, nil
+}
+
+// manifest API collects the hashes of the instrumented files from the manifests of the services,
+// the services built without a manifest are skipped
+func (s *server) manifest(c *gin.Context) {
+	var body ProfileParam
+	if err := c.ShouldBind(&body); err != nil {
+		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
+		return
+	}
+
+	allInfos := s.allServices()
+	filterAddrInfoList, err := filterAddrInfo(body.Service, body.Address, body.Force, allInfos)
+	if err != nil {
+		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, collectSourceHashes(filterAddrInfoList))
+}
+
+// collectSourceHashes collects the hashes of the instrumented files from the manifests of the services
+func collectSourceHashes(services []ServiceUnderTest) *SourceHashes {
+	hashes := &SourceHashes{Files: make(map[string]string)}
+	for _, addrInfo := range services {
+		if isPushAddress(addrInfo.Address) {
+			continue
+		}
+		mm, err := NewWorker(addrInfo.Address).Manifest(ProfileParam{})
+		if err != nil {
+			logger.Warnf("get manifest from [%s] failed, error: %s", addrInfo, err.Error())
+			continue
+		}
+		var m Manifest
+		if err := json.Unmarshal(mm, &m); err != nil {
+			logger.Warnf("invalid manifest from [%s], error: %s", addrInfo, err.Error())
+			continue
+		}
+		hashes.addManifest(&m)
+	}
+	return hashes
+}
+
+// report API renders the merged profile as annotated sources read from the source root,
+// the files whose source differs from the instrumented one are marked
+func (s *server) report(c *gin.Context) {
+	var body ProfileParam
+	if err := c.ShouldBind(&body); err != nil {
+		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
+		return
+	}
+	if s.SourceRoot == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no source root configured for the reports"})
+		return
+	}
+	checkout, err := FindCheckout(s.SourceRoot)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	merged, status, err := s.mergedProfile(body)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	// the selection is validated by mergedProfile already
+	services, _ := filterAddrInfo(body.Service, body.Address, true, s.allServices())
+	mismatches := checkout.CheckSources(profileSourceFiles(merged), collectSourceHashes(services))
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := RenderHTML(c.Writer, merged, checkout, mismatches); err != nil {
+		logger.Errorf("failed to render the report: %v", err)
+	}
 }
 
 // filterProfile filters profiles of the packages matching the coverFile pattern
@@ -264,21 +436,28 @@ func (s *server) clear(c *gin.Context) {
 		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 		return
 	}
-	svrsUnderTest := s.Store.GetAll()
+	svrsUnderTest := s.allServices()
 	filterAddrInfoList, err := filterAddrInfo(body.Service, body.Address, true, svrsUnderTest)
 	if err != nil {
 		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 		return
 	}
+	var results = make([]ServiceResult, 0, len(filterAddrInfoList))
 	for _, addrInfo := range filterAddrInfoList {
+		if isPushAddress(addrInfo.Address) {
+			// the instance can not be called, its counters are only cleared by itself
+			results = append(results, ServiceResult{Name: addrInfo.Name, Address: addrInfo.Address, Result: "skipped, the profile is pushed by the instance"})
+			continue
+		}
 		pp, err := NewWorker(addrInfo.Address).Clear(ProfileParam{})
 		if err != nil {
 			c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 			return
 		}
-		fmt.Fprintf(c.Writer, "Register service %s coverage counter %s", addrInfo.Address, string(pp))
+		results = append(results, ServiceResult{Name: addrInfo.Name, Address: addrInfo.Address, Result: strings.TrimSpace(string(pp))})
 	}
 
+	c.JSON(http.StatusOK, results)
 }
 
 func (s *server) initSystem(c *gin.Context) {
@@ -286,6 +465,8 @@ func (s *server) initSystem(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	s.pushed.reset()
+	s.health.reset()
 
 	c.JSON(http.StatusOK, "")
 }
@@ -296,20 +477,27 @@ func (s *server) removeServices(c *gin.Context) {
 		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 		return
 	}
-	svrsUnderTest := s.Store.GetAll()
+	svrsUnderTest := s.allServices()
 	filterAddrInfoList, err := filterAddrInfo(body.Service, body.Address, true, svrsUnderTest)
 	if err != nil {
 		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 		return
 	}
+	var results = make([]ServiceResult, 0, len(filterAddrInfoList))
 	for _, addrInfo := range filterAddrInfoList {
+		if s.pushed.remove(addrInfo.Address) {
+			results = append(results, ServiceResult{Name: addrInfo.Name, Address: addrInfo.Address, Result: "removed"})
+			continue
+		}
 		err := s.Store.Remove(addrInfo.Address)
 		if err != nil {
 			c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 			return
 		}
-		fmt.Fprintf(c.Writer, "Register service %s removed from the center.", addrInfo.Address)
+		results = append(results, ServiceResult{Name: addrInfo.Name, Address: addrInfo.Address, Result: "removed"})
 	}
+
+	c.JSON(http.StatusOK, results)
 }
 
 func convertProfile(p []byte) ([]*cover.Profile, error) {
@@ -341,8 +529,12 @@ func contains(arr []string, str string) bool {
 // filterAddrInfo filter address list by given service and address list
 func filterAddrInfo(serviceList, addressList []string, force bool, allInfos map[string][]string) (filterAddrList []ServiceUnderTest, err error) {
 	addressAll := []string{}
-	for _, addr := range allInfos {
+	nameOf := make(map[string]string)
+	for name, addr := range allInfos {
 		addressAll = append(addressAll, addr...)
+		for _, a := range addr {
+			nameOf[a] = name
+		}
 	}
 
 	if len(serviceList) != 0 && len(addressList) != 0 {
@@ -366,7 +558,7 @@ func filterAddrInfo(serviceList, addressList []string, force bool, allInfos map[
 	// Add matched addresses to map
 	for _, addr := range addressList {
 		if contains(addressAll, addr) {
-			filterAddrList = append(filterAddrList, ServiceUnderTest{Address: addr})
+			filterAddrList = append(filterAddrList, ServiceUnderTest{Name: nameOf[addr], Address: addr})
 			continue
 		}
 		if !force {
@@ -377,7 +569,7 @@ func filterAddrInfo(serviceList, addressList []string, force bool, allInfos map[
 
 	if len(addressList) == 0 && len(serviceList) == 0 {
 		for _, addr := range addressAll {
-			filterAddrList = append(filterAddrList, ServiceUnderTest{Address: addr})
+			filterAddrList = append(filterAddrList, ServiceUnderTest{Name: nameOf[addr], Address: addr})
 		}
 	}
 
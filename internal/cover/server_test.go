@@ -0,0 +1,108 @@
+package cover
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testCenter drives the routes of a center with a clock moved by the tests
+type testCenter struct {
+	t      *testing.T
+	server *server
+	router *gin.Engine
+	now    time.Time
+}
+
+func newTestCenter(t *testing.T, s *server) *testCenter {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c := &testCenter{t: t, server: s, now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s.now = func() time.Time { return c.now }
+	c.router = s.Route(io.Discard)
+	return c
+}
+
+// advance moves the clock of the center forward
+func (c *testCenter) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// do sends the request to the center and checks the status of the response
+func (c *testCenter) do(method, target, body string, status int) string {
+	c.t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if strings.HasPrefix(body, "{") {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, req)
+	if w.Code != status {
+		c.t.Fatalf("%s %s: got the status %d, want %d, body: %s", method, target, w.Code, status, w.Body.String())
+	}
+	return w.Body.String()
+}
+
+func (c *testCenter) register(name, address string) {
+	c.t.Helper()
+	c.do(http.MethodPost, "/v1/cover/register?"+url.Values{"name": {name}, "address": {address}}.Encode(), "", http.StatusOK)
+}
+
+func (c *testCenter) heartbeat(name, address string, status int) {
+	c.t.Helper()
+	c.do(http.MethodPost, "/v1/cover/heartbeat?"+url.Values{"name": {name}, "address": {address}}.Encode(), "", status)
+}
+
+func (c *testCenter) services() map[string][]string {
+	c.t.Helper()
+	var services map[string][]string
+	if err := json.Unmarshal([]byte(c.do(http.MethodGet, "/v1/cover/list", "", http.StatusOK)), &services); err != nil {
+		c.t.Fatal(err)
+	}
+	return services
+}
+
+func (c *testCenter) health() []ServiceHealth {
+	c.t.Helper()
+	var health []ServiceHealth
+	if err := json.Unmarshal([]byte(c.do(http.MethodGet, "/v1/cover/list?health=true", "", http.StatusOK)), &health); err != nil {
+		c.t.Fatal(err)
+	}
+	return health
+}
+
+// newFakeAgent starts an agent answering the probes and serving the profile
+func newFakeAgent(t *testing.T, profile string) *httptest.Server {
+	t.Helper()
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, profile)
+	}))
+	t.Cleanup(agent.Close)
+	return agent
+}
+
+func TestHeartbeat(t *testing.T) {
+	c := newTestCenter(t, NewMemoryBasedServer())
+	c.heartbeat("svc", "http://127.0.0.1:7001", http.StatusNotFound)
+	c.register("svc", "http://127.0.0.1:7001")
+	c.heartbeat("svc", "http://127.0.0.1:7001", http.StatusOK)
+	// the address is normalized as it is registered
+	c.heartbeat("svc", "http://127.0.0.1:7001/", http.StatusOK)
+	c.heartbeat("other", "http://127.0.0.1:7001", http.StatusNotFound)
+	c.heartbeat("svc", "ftp://127.0.0.1:7001", http.StatusBadRequest)
+
+	c.register("keep", "http://127.0.0.1:7002")
+	c.do(http.MethodPost, "/v1/cover/remove", `{"address":["http://127.0.0.1:7001"]}`, http.StatusOK)
+	c.heartbeat("keep", "http://127.0.0.1:7002", http.StatusOK)
+	// the agent registers again on 404
+	c.heartbeat("svc", "http://127.0.0.1:7001", http.StatusNotFound)
+	c.register("svc", "http://127.0.0.1:7001")
+	c.heartbeat("svc", "http://127.0.0.1:7001", http.StatusOK)
+}
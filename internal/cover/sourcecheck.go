@@ -0,0 +1,152 @@
+package cover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/cover"
+)
+
+// SourceHashes are the content hashes of the instrumented files reported by the services
+type SourceHashes struct {
+	Files     map[string]string `json:"files"`               // file name in the profile to the sha256 of its source
+	Conflicts []string          `json:"conflicts,omitempty"` // files instrumented from different sources by the services
+}
+
+// addManifest records the hashes of the manifest, the files hashed differently before are conflicts
+func (h *SourceHashes) addManifest(m *Manifest) {
+	if h.Files == nil {
+		h.Files = make(map[string]string)
+	}
+	for _, f := range m.Files {
+		hash, ok := h.Files[f.Name]
+		if !ok {
+			h.Files[f.Name] = f.SHA256
+			continue
+		}
+		if hash != f.SHA256 && !contains(h.Conflicts, f.Name) {
+			h.Conflicts = append(h.Conflicts, f.Name)
+		}
+	}
+	sort.Strings(h.Conflicts)
+}
+
+// Mismatch is a file of the profile whose checked out source is not the instrumented one
+type Mismatch struct {
+	File   string
+	Reason string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: %s", m.File, m.Reason)
+}
+
+// Checkout locates the files of the profiles in the module checked out at Root
+type Checkout struct {
+	Root   string // directory of go.mod
+	Module string // module path
+}
+
+// FindCheckout finds the module containing dir
+func FindCheckout(dir string) (*Checkout, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		content, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			module := modfile.ModulePath(content)
+			if module == "" {
+				return nil, fmt.Errorf("no module path found in %s", filepath.Join(dir, "go.mod"))
+			}
+			return &Checkout{Root: dir, Module: module}, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, errors.New("go.mod not found, the source check only supports go modules projects")
+		}
+		dir = parent
+	}
+}
+
+// Path returns the path of the file of the profile in the checkout,
+// false if the file does not belong to the module
+func (c *Checkout) Path(name string) (string, bool) {
+	rel := strings.TrimPrefix(name, c.Module+"/")
+	if rel == name {
+		return "", false
+	}
+	return filepath.Join(c.Root, filepath.FromSlash(rel)), true
+}
+
+// CheckSources compares the files of the profile in the checkout with the instrumented ones,
+// the files without a hash, such as the ones reported by the services built before, are not checked
+func (c *Checkout) CheckSources(files []string, hashes *SourceHashes) []Mismatch {
+	var mismatches []Mismatch
+	for _, name := range files {
+		if contains(hashes.Conflicts, name) {
+			mismatches = append(mismatches, Mismatch{File: name, Reason: "instrumented from different sources by the services"})
+			continue
+		}
+		hash, ok := hashes.Files[name]
+		if !ok {
+			continue
+		}
+		local, ok := c.Path(name)
+		if !ok {
+			continue
+		}
+		localHash, err := fileHash(local)
+		if err != nil {
+			mismatches = append(mismatches, Mismatch{File: name, Reason: "not found in the checkout"})
+			continue
+		}
+		if localHash != hash {
+			mismatches = append(mismatches, Mismatch{File: name, Reason: "modified since it was instrumented"})
+		}
+	}
+	return mismatches
+}
+
+// ProfileSourceFiles returns the source files of the profile, sorted,
+// the functions of func mode and the branches of branch mode are reported under their files
+func ProfileSourceFiles(profile []byte) ([]string, error) {
+	profiles, err := cover.ParseProfilesFromReader(bytes.NewReader(profile))
+	if err != nil {
+		return nil, err
+	}
+	return profileSourceFiles(profiles), nil
+}
+
+func profileSourceFiles(profiles []*cover.Profile) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, p := range profiles {
+		name := sourceFileName(p.FileName)
+		if !seen[name] {
+			seen[name] = true
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// sourceFileName returns the source file of the file name in the profile,
+// such as pkg/file.go for pkg/file.go:(*T).M of func mode or pkg/file.go:branch.true of branch mode
+func sourceFileName(name string) string {
+	if i := strings.Index(name, ".go:"); i >= 0 {
+		return name[:i+len(".go")]
+	}
+	return name
+}
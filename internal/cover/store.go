@@ -1,9 +1,13 @@
 package cover
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 )
 
@@ -130,3 +134,159 @@ func NewMemoryStore() Store {
 		servicesMap: make(map[string][]string, 0),
 	}
 }
+
+// fileStore holds the registered services into memory and persistent to a local file
+type fileStore struct {
+	mu             sync.RWMutex
+	persistentFile string
+
+	memoryStore Store
+}
+
+// NewFileStore creates a store using local file,
+// services registered before are loaded from the file.
+func NewFileStore(persistenceFile string) (Store, error) {
+	path, err := filepath.Abs(persistenceFile)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	l := &fileStore{
+		persistentFile: path,
+		memoryStore:    NewMemoryStore(),
+	}
+
+	if err := l.load(); err != nil {
+		return nil, fmt.Errorf("load failed, file: %s, err: %w", l.persistentFile, err)
+	}
+
+	return l, nil
+}
+
+// Add adds the given service to file Store
+func (l *fileStore) Add(s ServiceUnderTest) error {
+	if err := l.memoryStore.Add(s); err != nil {
+		return err
+	}
+
+	// persistent to local store
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.appendToFile(s)
+}
+
+// Get returns the registered service information with the given name
+func (l *fileStore) Get(name string) []string {
+	return l.memoryStore.Get(name)
+}
+
+// Get returns all the registered service information
+func (l *fileStore) GetAll() map[string][]string {
+	return l.memoryStore.GetAll()
+}
+
+// Init cleanup all the registered service information
+// and the local persistent file
+func (l *fileStore) Init() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.memoryStore.Init(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(l.persistentFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete the local persistent file, err: %w", err)
+	}
+	return nil
+}
+
+// Set stores the services information into memory and rewrites the local persistent file
+func (l *fileStore) Set(services map[string][]string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.memoryStore.Set(services); err != nil {
+		return err
+	}
+	return l.writeToFile(services)
+}
+
+// Remove the service from the memory store and the file store
+func (l *fileStore) Remove(removeAddr string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.memoryStore.Remove(removeAddr); err != nil {
+		return err
+	}
+	return l.writeToFile(l.memoryStore.GetAll())
+}
+
+// load all registered service from file to memory
+func (l *fileStore) load() error {
+	var svrsMap = make(map[string][]string, 0)
+
+	f, err := os.Open(l.persistentFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open file, path: %s, err: %w", l.persistentFile, err)
+	}
+	defer f.Close()
+
+	ns := bufio.NewScanner(f)
+	for ns.Scan() {
+		name, addr, ok := strings.Cut(ns.Text(), "&")
+		if !ok || name == "" || addr == "" {
+			continue
+		}
+		if !contains(svrsMap[name], addr) {
+			svrsMap[name] = append(svrsMap[name], addr)
+		}
+	}
+
+	if err := ns.Err(); err != nil {
+		return fmt.Errorf("read file failed, file: %s, err: %w", l.persistentFile, err)
+	}
+
+	// set information to memory
+	return l.memoryStore.Set(svrsMap)
+}
+
+func (l *fileStore) writeToFile(services map[string][]string) error {
+	var sb strings.Builder
+	for name, addrs := range services {
+		for _, addr := range addrs {
+			sb.WriteString(format(ServiceUnderTest{Name: name, Address: addr}) + "\n")
+		}
+	}
+
+	// write to a temporary file first, so a crash never leaves a truncated store behind
+	tmpFile := l.persistentFile + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, l.persistentFile)
+}
+
+func (l *fileStore) appendToFile(s ServiceUnderTest) error {
+	f, err := os.OpenFile(l.persistentFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.WriteString(format(s) + "\n"); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func format(s ServiceUnderTest) string {
+	return fmt.Sprintf("%s&%s", s.Name, s.Address)
+}
@@ -0,0 +1,75 @@
+package cover
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// the suffixes of the file names the branch mode reports the outcomes of the branches under
+const (
+	branchTrueSuffix  = ":branch.true"
+	branchFalseSuffix = ":branch.false"
+)
+
+// IsBranchFile reports whether the profile of the file holds the outcomes of branches instead of statements
+func IsBranchFile(name string) bool {
+	return strings.HasSuffix(name, branchTrueSuffix) || strings.HasSuffix(name, branchFalseSuffix)
+}
+
+// Summary is the statement and branch coverage of a profile
+type Summary struct {
+	Statements        int
+	CoveredStatements int
+	Branches          int // outcomes of the branches, only reported in branch mode
+	CoveredBranches   int
+}
+
+// Summarize counts the covered statements and branch outcomes of the profiles
+func Summarize(profiles []*cover.Profile) Summary {
+	var s Summary
+	for _, p := range profiles {
+		branch := IsBranchFile(p.FileName)
+		for _, b := range p.Blocks {
+			if branch {
+				s.Branches++
+				if b.Count > 0 {
+					s.CoveredBranches++
+				}
+				continue
+			}
+			s.Statements += b.NumStmt
+			if b.Count > 0 {
+				s.CoveredStatements += b.NumStmt
+			}
+		}
+	}
+	return s
+}
+
+func (s Summary) String() string {
+	out := fmt.Sprintf("statement coverage: %s (%d/%d)", Percent(s.CoveredStatements, s.Statements), s.CoveredStatements, s.Statements)
+	if s.Branches != 0 {
+		out += fmt.Sprintf(", branch coverage: %s (%d/%d)", Percent(s.CoveredBranches, s.Branches), s.CoveredBranches, s.Branches)
+	}
+	return out
+}
+
+// Percent formats n/d as a percentage
+func Percent(n, d int) string {
+	if d == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(d))
+}
+
+// SummarizeProfile summarizes the raw profile
+func SummarizeProfile(profile []byte) (Summary, error) {
+	profiles, err := cover.ParseProfilesFromReader(bytes.NewReader(profile))
+	if err != nil {
+		return Summary{}, err
+	}
+	return Summarize(profiles), nil
+}